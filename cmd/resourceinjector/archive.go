@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// ArchiveSourceSpec is a source read from an archive.
+type ArchiveSourceSpec struct {
+	// Path of the `.tar.gz`, `.tgz`, `.tar` or `.zip` archive, relative to the function config.
+	Path string `yaml:"path" json:"path"`
+	// Digest the archive is verified against, in the `sha256:<hex>` format.
+	Digest string `yaml:"digest" json:"digest"`
+	// Dir is the directory within the archive to render.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// archiveSource unpacks the archive into an in-memory file system.
+func archiveSource(spec *ArchiveSourceSpec, baseDir string) (*sourceLocation, error) {
+	if spec.Path == "" {
+		return nil, fmt.Errorf("archive path must be specified")
+	}
+	if spec.Digest == "" {
+		return nil, fmt.Errorf("archive digest must be specified")
+	}
+	archivePath := resolvePath(baseDir, spec.Path)
+	if !isArchive(archivePath) {
+		return nil, fmt.Errorf("unsupported archive %q, must be a .tar.gz, .tgz, .tar or .zip file", spec.Path)
+	}
+	content, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if err := verifyDigest(content, spec.Digest); err != nil {
+		return nil, fmt.Errorf("archive %q: %w", spec.Path, err)
+	}
+
+	fSys := filesys.MakeFsInMemory()
+	if err := unpackArchive(archivePath, content, fSys, memSourceRoot); err != nil {
+		return nil, fmt.Errorf("failed to unpack archive %q: %w", spec.Path, err)
+	}
+
+	sourcePath, err := subPath(memSourceRoot, spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	absPath, err := filepath.Abs(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256Digest(content)
+	return &sourceLocation{
+		fSys:     fSys,
+		path:     sourcePath,
+		identity: digest,
+		origin:   remoteOrigin(absPath, digest, spec.Dir),
+		cleanup:  func() {},
+	}, nil
+}
+
+// isArchive reports whether the file name has the extension of a supported archive.
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxUnpackedSize is the largest total size of the files unpacked from an
+// archive, which guards the in-memory file system against decompression bombs.
+const maxUnpackedSize = 256 << 20
+
+// unpacker writes the entries of archives into the root directory, up to
+// maxUnpackedSize bytes in total.
+type unpacker struct {
+	fSys      filesys.FileSystem
+	root      string
+	remaining int64
+}
+
+func newUnpacker(fSys filesys.FileSystem, root string) *unpacker {
+	return &unpacker{fSys: fSys, root: root, remaining: maxUnpackedSize}
+}
+
+// unpackArchive unpacks the archive into the root directory, picking the
+// format by the extension of its name.
+func unpackArchive(name string, content []byte, fSys filesys.FileSystem, root string) error {
+	u := newUnpacker(fSys, root)
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return u.untarGzip(bytes.NewReader(content))
+	case strings.HasSuffix(name, ".tar"):
+		return u.untar(bytes.NewReader(content))
+	case strings.HasSuffix(name, ".zip"):
+		return u.unzip(content)
+	}
+	return fmt.Errorf("unsupported archive %q", name)
+}
+
+// untarGzip unpacks a gzipped tarball.
+func (u *unpacker) untarGzip(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return u.untar(gz)
+}
+
+// untar unpacks a tarball. Only directories and regular files are unpacked.
+func (u *unpacker) untar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := u.fSys.MkdirAll(archiveEntryPath(u.root, hdr.Name)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := u.writeFile(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unzip unpacks a zip archive.
+func (u *unpacker) unzip(content []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			if err := u.fSys.MkdirAll(archiveEntryPath(u.root, f.Name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = u.writeFile(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile unpacks an entry into its file, reading no more than what is left
+// of maxUnpackedSize, as sizes recorded in archives cannot be trusted.
+func (u *unpacker) writeFile(name string, r io.Reader) error {
+	content, err := io.ReadAll(io.LimitReader(r, u.remaining+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) > u.remaining {
+		return fmt.Errorf("archive exceeds the limit of %d bytes once unpacked", maxUnpackedSize)
+	}
+	u.remaining -= int64(len(content))
+	return u.fSys.WriteFile(archiveEntryPath(u.root, name), content)
+}
+
+// archiveEntryPath places an archive entry under the root directory. Cleaning
+// the entry as an absolute path keeps entries like `../x` within the root.
+func archiveEntryPath(root, name string) string {
+	return filepath.Join(root, filepath.FromSlash(path.Clean("/"+name)))
+}
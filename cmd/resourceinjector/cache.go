@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	// cacheDirEnv enables the render cache for every source, including the
+	// sources of nested injectors.
+	cacheDirEnv = "RESOURCEINJECTOR_CACHE_DIR"
+	// defaultCacheMaxEntries is the number of entries kept when none is configured.
+	defaultCacheMaxEntries = 64
+	// defaultCacheMaxAge is how long unused entries are kept when none is configured.
+	defaultCacheMaxAge = 7 * 24 * time.Hour
+	// gitCacheDir is the subdirectory of the cache holding git checkouts.
+	gitCacheDir = "git"
+	// urlCacheDir is the subdirectory of the cache holding downloaded url sources.
+	urlCacheDir = "url"
+)
+
+// CacheSpec configures the on-disk cache of rendered sources.
+type CacheSpec struct {
+	// Dir is the cache directory, relative to the function config. Defaults to $RESOURCEINJECTOR_CACHE_DIR.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	// MaxEntries is the number of entries kept, evicting the least recently used ones.
+	MaxEntries int `yaml:"maxEntries,omitempty" json:"maxEntries,omitempty"`
+	// MaxAge is how long unused entries are kept, e.g. `24h`.
+	MaxAge string `yaml:"maxAge,omitempty" json:"maxAge,omitempty"`
+}
+
+// renderCache stores the output of kustomize builds, keyed by the build
+// options and validated against the content of every file the build loaded.
+type renderCache struct {
+	dir        string
+	maxEntries int
+	maxAge     time.Duration
+}
+
+// cacheEntry is a cached build output along with the files it depends on.
+type cacheEntry struct {
+	// Files loaded by the build.
+	Files []string `json:"files"`
+	// Trees are the kustomization directories of the build, whose whole content is tracked.
+	Trees []string `json:"trees"`
+	// Fingerprint is the hash of the files and trees when the build ran.
+	Fingerprint string `json:"fingerprint"`
+	Output      []byte `json:"output"`
+}
+
+// sourceCache returns the cache configured for the source, or nil if caching is disabled.
+func sourceCache(spec *CacheSpec, baseDir string) (*renderCache, error) {
+	c := &renderCache{maxEntries: defaultCacheMaxEntries, maxAge: defaultCacheMaxAge}
+	c.dir = os.Getenv(cacheDirEnv)
+	if spec != nil {
+		if spec.Dir != "" {
+			c.dir = resolvePath(baseDir, spec.Dir)
+		}
+		if spec.MaxEntries < 0 {
+			return nil, fmt.Errorf("cache maxEntries must not be negative")
+		}
+		if spec.MaxEntries > 0 {
+			c.maxEntries = spec.MaxEntries
+		}
+		if spec.MaxAge != "" {
+			maxAge, err := time.ParseDuration(spec.MaxAge)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cache maxAge: %w", err)
+			}
+			c.maxAge = maxAge
+		}
+	}
+	if c.dir == "" {
+		if spec != nil {
+			return nil, fmt.Errorf("cache dir must be specified, either in the source or with %s", cacheDirEnv)
+		}
+		return nil, nil
+	}
+	return c, nil
+}
+
+// build runs the kustomize build, or returns its output from the cache when
+// none of the files it loaded have changed since. The identity distinguishes
+// sources unpacked in memory, which share their paths.
+func (c *renderCache) build(
+	fSys filesys.FileSystem, buildPath string, opts *krusty.Options, k *ktypes.Kustomization, identity string,
+) ([]byte, error) {
+	if !cacheable(opts) {
+		return build(fSys, buildPath, opts)
+	}
+	key, err := cacheKey(buildPath, opts, k, identity)
+	if err != nil {
+		return nil, err
+	}
+	entryPath := filepath.Join(c.dir, key+".json")
+	if output, ok := c.lookup(fSys, entryPath); ok {
+		return output, nil
+	}
+
+	recorder := &recordingFs{FileSystem: fSys, files: map[string]bool{}, trees: map[string]bool{}}
+	output, err := build(recorder, buildPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{Files: sortedKeys(recorder.files), Trees: sortedKeys(recorder.trees), Output: output}
+	entry.Fingerprint, err = fingerprint(fSys, entry.Files, entry.Trees)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.store(entryPath, entry); err != nil {
+		return nil, fmt.Errorf("failed to write render cache: %w", err)
+	}
+	return output, nil
+}
+
+// lookup returns the cached output if the entry exists and is up to date.
+func (c *renderCache) lookup(fSys filesys.FileSystem, entryPath string) ([]byte, bool) {
+	content, err := os.ReadFile(entryPath)
+	if err != nil {
+		return nil, false
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(content, entry); err != nil {
+		return nil, false
+	}
+	current, err := fingerprint(fSys, entry.Files, entry.Trees)
+	if err != nil || current != entry.Fingerprint {
+		return nil, false
+	}
+	touch(entryPath)
+	return entry.Output, true
+}
+
+// store writes the entry atomically, then evicts stale entries.
+func (c *renderCache) store(entryPath string, entry *cacheEntry) error {
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(entryPath, content); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+// writeFileAtomic writes the file through a temporary file renamed in place,
+// so that concurrent renders never read a partial file.
+func writeFileAtomic(name string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// evict removes the entries unused for longer than maxAge, then the least
+// recently used entries beyond maxEntries. Rendered builds, git checkouts and
+// downloads are evicted separately.
+func (c *renderCache) evict() error {
+	isBuild := func(e os.DirEntry) bool { return !e.IsDir() && strings.HasSuffix(e.Name(), ".json") }
+	if err := c.evictDir(c.dir, isBuild); err != nil {
+		return err
+	}
+	// Temporary files and checkouts in progress are left to their writers.
+	isEntry := func(e os.DirEntry) bool { return !strings.HasPrefix(e.Name(), ".") }
+	for _, sub := range []string{gitCacheDir, urlCacheDir} {
+		if err := c.evictDir(filepath.Join(c.dir, sub), isEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evictDir evicts the entries of dir matched by isEntry.
+func (c *renderCache) evictDir(dir string, isEntry func(os.DirEntry) bool) error {
+	dirEntries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	type cached struct {
+		path    string
+		modTime time.Time
+	}
+	var entries []cached
+	for _, e := range dirEntries {
+		if !isEntry(e) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cached{filepath.Join(dir, e.Name()), info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.After(entries[j].modTime) })
+
+	cutoff := time.Now().Add(-c.maxAge)
+	for i, e := range entries {
+		if i >= c.maxEntries || e.modTime.Before(cutoff) {
+			if err := os.RemoveAll(e.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// touch marks the cache entry at path as recently used.
+func touch(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
+
+// cacheable reports whether the build output only depends on the files the
+// build loads through its file system. Plugins other than the builtins, like
+// exec and container functions, and helm read files and environment variables
+// the cache cannot track, so builds that may run them are not cached.
+func cacheable(opts *krusty.Options) bool {
+	pc := opts.PluginConfig
+	return pc == nil || (pc.PluginRestrictions == ktypes.PluginRestrictionsBuiltinsOnly && !pc.HelmConfig.Enabled)
+}
+
+// cacheKey hashes the build path along with the effective build options and
+// the inline kustomization.
+func cacheKey(buildPath string, opts *krusty.Options, k *ktypes.Kustomization, identity string) (string, error) {
+	absPath, err := filepath.Abs(buildPath)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(struct {
+		Path          string
+		Identity      string
+		Options       *krusty.Options
+		Kustomization *ktypes.Kustomization
+	}{absPath, identity, opts, k})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fingerprint hashes the state of the files and the content of the trees.
+func fingerprint(fSys filesys.FileSystem, files, trees []string) (string, error) {
+	h := sha256.New()
+	for _, path := range files {
+		fmt.Fprintf(h, "file %s %s\n", path, fileState(fSys, path))
+	}
+	for _, tree := range trees {
+		err := fSys.Walk(tree, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			fmt.Fprintf(h, "tree %s %s\n", path, fileState(fSys, path))
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileState describes whether a path exists, and the hash of its content if it is a file.
+func fileState(fSys filesys.FileSystem, path string) string {
+	switch {
+	case fSys.IsDir(path):
+		return "dir"
+	case !fSys.Exists(path):
+		return "missing"
+	}
+	content, err := fSys.ReadFile(path)
+	if err != nil {
+		return "unreadable"
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingFs records the files a build loads. The directories of the
+// kustomization files are recorded as trees, so that files referenced in ways
+// the file system does not see are tracked as well.
+type recordingFs struct {
+	filesys.FileSystem
+	files map[string]bool
+	trees map[string]bool
+}
+
+func (r *recordingFs) record(path string) {
+	if absPath, err := filepath.Abs(path); err == nil {
+		r.files[absPath] = true
+	}
+}
+
+func (r *recordingFs) Open(path string) (filesys.File, error) {
+	r.record(path)
+	return r.FileSystem.Open(path)
+}
+
+func (r *recordingFs) IsDir(path string) bool {
+	r.record(path)
+	return r.FileSystem.IsDir(path)
+}
+
+func (r *recordingFs) Exists(path string) bool {
+	r.record(path)
+	return r.FileSystem.Exists(path)
+}
+
+func (r *recordingFs) ReadFile(path string) ([]byte, error) {
+	r.record(path)
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if filepath.Base(path) == name {
+			if absDir, err := filepath.Abs(filepath.Dir(path)); err == nil {
+				r.trees[absDir] = true
+			}
+		}
+	}
+	return r.FileSystem.ReadFile(path)
+}
+
+func (r *recordingFs) ReadDir(path string) ([]string, error) {
+	r.record(path)
+	return r.FileSystem.ReadDir(path)
+}
+
+func (r *recordingFs) Glob(pattern string) ([]string, error) {
+	matches, err := r.FileSystem.Glob(pattern)
+	for _, match := range matches {
+		r.record(match)
+	}
+	return matches, err
+}
+
+// sortedKeys returns the keys of the set in order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ChecksumSpec defines where the checksum of the injected content is written,
+// e.g. into pod template annotations to roll Deployments when the content changes.
+type ChecksumSpec struct {
+	Targets []*transform.TargetSelector `json:"targets,omitempty" yaml:"targets,omitempty"`
+}
+
+// applyChecksum writes the sha256 checksum of the injected values into the checksum targets.
+func applyChecksum(items []*yaml.RNode, values []*yaml.RNode, spec *ChecksumSpec) ([]*yaml.RNode, error) {
+	for _, target := range spec.Targets {
+		// Unlike replacements, there is no sensible default field for a checksum.
+		if len(target.FieldPaths) == 0 {
+			return nil, fmt.Errorf("checksum target must specify fieldPaths")
+		}
+	}
+
+	sum, err := checksum(values)
+	if err != nil {
+		return nil, err
+	}
+	setter := setValue{Value: yaml.NewStringRNode(sum)}
+	return transform.Apply(&setter, items, spec.Targets)
+}
+
+// checksum returns the hex encoded sha256 of the serialized values. Each value
+// is prefixed with its length, so that values cannot run into each other.
+func checksum(values []*yaml.RNode) (string, error) {
+	h := sha256.New()
+	for _, value := range values {
+		content := value.YNode().Value
+		if value.YNode().Kind != yaml.ScalarNode {
+			var err error
+			content, err = value.String()
+			if err != nil {
+				return "", err
+			}
+		}
+		fmt.Fprintf(h, "%d:", len(content))
+		h.Write([]byte(content))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
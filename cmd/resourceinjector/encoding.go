@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/midiparse/kustomize-plugins/internal/yq"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	"github.com/pelletier/go-toml/v2"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// SourceEncodingType is a typed string for the encodings of the injected string.
+type SourceEncodingType string
+
+// SourceEncoding enumeration for the supported output encodings.
+const (
+	// SourceEncodingYAML encodes the source as YAML (default).
+	SourceEncodingYAML SourceEncodingType = "yaml"
+	// SourceEncodingJSON encodes the source as compact JSON, one line per document.
+	SourceEncodingJSON SourceEncodingType = "json"
+	// SourceEncodingPrettyJSON encodes the source as indented JSON.
+	SourceEncodingPrettyJSON SourceEncodingType = "prettyJson"
+	// SourceEncodingBase64 encodes the YAML source as base64.
+	SourceEncodingBase64 SourceEncodingType = "base64"
+	// SourceEncodingGzipBase64 compresses the YAML source with gzip and encodes it as base64.
+	SourceEncodingGzipBase64 SourceEncodingType = "gzipBase64"
+	// SourceEncodingTOML encodes the source as TOML.
+	SourceEncodingTOML SourceEncodingType = "toml"
+	// SourceEncodingProperties encodes the source as Java properties.
+	SourceEncodingProperties SourceEncodingType = "properties"
+	// SourceEncodingINI encodes the source as INI.
+	SourceEncodingINI SourceEncodingType = "ini"
+	// SourceEncodingXML encodes the source as XML.
+	SourceEncodingXML SourceEncodingType = "xml"
+)
+
+// encodeSource serializes the documents into a string using the given encoding
+// and format. The trailing newline policy of the format applies to the content
+// before it is base64 encoded.
+func encodeSource(docs []*yaml.RNode, encoding SourceEncodingType, format *FormatSpec) (string, error) {
+	if encoding == SourceEncodingBase64 || encoding == SourceEncodingGzipBase64 {
+		content, err := documentsString(docs, format.indent())
+		if err != nil {
+			return "", err
+		}
+		return encodeBytes([]byte(format.trimNewlines(content)), encoding)
+	}
+	content, err := encodeDocuments(docs, encoding, format.indent())
+	if err != nil {
+		return "", err
+	}
+	return format.trimNewlines(content), nil
+}
+
+// encodeDocuments serializes the documents into a string using one of the
+// encodings that parse the source.
+func encodeDocuments(docs []*yaml.RNode, encoding SourceEncodingType, indent int) (string, error) {
+	switch encoding {
+	case SourceEncodingYAML, "":
+		return documentsString(docs, indent)
+	case SourceEncodingJSON:
+		return yqEncode(docs, yqlib.NewJSONEncoder(jsonPreferences(0)), true)
+	case SourceEncodingPrettyJSON:
+		return yqEncode(docs, yqlib.NewJSONEncoder(jsonPreferences(indent)), true)
+	case SourceEncodingTOML:
+		return tomlEncode(docs)
+	case SourceEncodingProperties:
+		return yqEncode(docs, yqlib.NewPropertiesEncoder(yqlib.ConfiguredPropertiesPreferences.Copy()), false)
+	case SourceEncodingINI:
+		return yqEncode(docs, yqlib.NewINIEncoder(), false)
+	case SourceEncodingXML:
+		return yqEncode(docs, yqlib.NewXMLEncoder(yqlib.ConfiguredXMLPreferences.Copy()), false)
+	default:
+		return "", fmt.Errorf("unrecognized source encoding: %q", encoding)
+	}
+}
+
+// encodeBytes encodes raw content with one of the encodings that do not require
+// parsing it, i.e. yaml (as is), base64 or gzipBase64.
+func encodeBytes(content []byte, encoding SourceEncodingType) (string, error) {
+	switch encoding {
+	case SourceEncodingYAML, "":
+		return string(content), nil
+	case SourceEncodingBase64:
+		return base64.StdEncoding.EncodeToString(content), nil
+	case SourceEncodingGzipBase64:
+		var buf bytes.Buffer
+		// The zero gzip header carries no timestamp, so the output is stable across builds.
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(content); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	default:
+		return "", fmt.Errorf("encoding %q requires parsing the source", encoding)
+	}
+}
+
+func jsonPreferences(indent int) yqlib.JsonPreferences {
+	prefs := yqlib.ConfiguredJSONPreferences.Copy()
+	prefs.Indent = indent
+	prefs.ColorsEnabled = false
+	prefs.UnwrapScalar = false
+	return prefs
+}
+
+// yqEncode encodes the documents with a yq encoder. Formats without a notion of
+// multiple documents require the source to be joined into a single document.
+func yqEncode(docs []*yaml.RNode, encoder yqlib.Encoder, multiDoc bool) (string, error) {
+	if encoder == nil {
+		return "", fmt.Errorf("encoder is not supported by this build")
+	}
+	if !multiDoc && len(docs) != 1 {
+		return "", fmt.Errorf("encoding requires a single document, got %d: use join %q or %q",
+			len(docs), SourceJoinSequence, SourceJoinList)
+	}
+	var buf bytes.Buffer
+	// Like yq, encode through a buffered writer: the XML encoder does not flush
+	// its output, but writes into a *bufio.Writer passed to it directly.
+	w := bufio.NewWriter(&buf)
+	for _, doc := range docs {
+		candidate, err := yq.ToCandidateNode(doc.YNode())
+		if err != nil {
+			return "", err
+		}
+		if err := encoder.Encode(w, candidate); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// tomlEncode encodes a single mapping document as TOML. The yq TOML encoder only
+// supports scalars, so go-toml is used instead.
+func tomlEncode(docs []*yaml.RNode) (string, error) {
+	if len(docs) != 1 {
+		return "", fmt.Errorf("encoding requires a single document, got %d: use join %q or %q",
+			len(docs), SourceJoinSequence, SourceJoinList)
+	}
+	if docs[0].YNode().Kind != yaml.MappingNode {
+		return "", fmt.Errorf("TOML encoding requires a mapping, got %s", docs[0].YNode().Tag)
+	}
+	var value map[string]interface{}
+	if err := docs[0].YNode().Decode(&value); err != nil {
+		return "", err
+	}
+	out, err := toml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
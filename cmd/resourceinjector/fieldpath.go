@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// fieldPaths returns the field paths to extract from the rendered source.
+func (s *SourceSpec) fieldPaths() []string {
+	var paths []string
+	if s.FieldPath != "" {
+		paths = append(paths, s.FieldPath)
+	}
+	return append(paths, s.FieldPaths...)
+}
+
+// projectFieldPaths extracts the field paths from a rendered resource. Several
+// field paths must all refer to mappings, which are merged into one, with the
+// fields of later paths taking precedence.
+func projectFieldPaths(res *yaml.RNode, fieldPaths []string) (*yaml.RNode, error) {
+	if len(fieldPaths) == 1 {
+		return lookupFieldPath(res, fieldPaths[0])
+	}
+
+	merged := yaml.NewMapRNode(nil)
+	for _, fp := range fieldPaths {
+		value, err := lookupFieldPath(res, fp)
+		if err != nil {
+			return nil, err
+		}
+		if value.YNode().Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("field path %q must refer to a mapping to be merged with other field paths", fp)
+		}
+		err = value.VisitFields(func(node *yaml.MapNode) error {
+			return merged.PipeE(yaml.SetField(node.Key.YNode().Value, node.Value))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// lookupFieldPath looks up a kustomize style field path in a rendered resource,
+// e.g. `data.[app.yaml]` or `spec.containers.[name=app]`. Paths with wildcards
+// return a sequence of all matches.
+func lookupFieldPath(res *yaml.RNode, fp string) (*yaml.RNode, error) {
+	path := kyaml_utils.SmarterPathSplitter(fp, ".")
+	matches, err := res.Pipe(&yaml.PathMatcher{Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup field path %q in rendered source: %w", fp, err)
+	}
+	var elements []*yaml.RNode
+	if matches != nil {
+		elements, err = matches.Elements()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup field path %q in rendered source: %w", fp, err)
+		}
+	}
+	if len(elements) == 0 {
+		return nil, fmt.Errorf("field path %q not found in rendered source", fp)
+	}
+
+	for _, p := range path {
+		if strings.Contains(p, "*") {
+			return sequenceOf(elements), nil
+		}
+	}
+	return elements[0], nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ScalarStyleType is a typed string for the YAML styles of the injected string.
+type ScalarStyleType string
+
+// ScalarStyle enumeration for the styles of the injected string.
+const (
+	// ScalarStyleAuto leaves the style to the encoder (default).
+	ScalarStyleAuto ScalarStyleType = "auto"
+	// ScalarStyleLiteral renders the string as a literal block (`|`).
+	ScalarStyleLiteral ScalarStyleType = "literal"
+	// ScalarStyleFolded renders the string as a folded block (`>`).
+	ScalarStyleFolded ScalarStyleType = "folded"
+	// ScalarStyleDoubleQuoted renders the string in double quotes.
+	ScalarStyleDoubleQuoted ScalarStyleType = "doubleQuoted"
+	// ScalarStyleSingleQuoted renders the string in single quotes.
+	ScalarStyleSingleQuoted ScalarStyleType = "singleQuoted"
+)
+
+// TrailingNewlineType is a typed string for the trailing newline policies of the injected string.
+type TrailingNewlineType string
+
+// TrailingNewline enumeration for the trailing newline policies.
+const (
+	// TrailingNewlineKeep keeps the string as encoded (default).
+	TrailingNewlineKeep TrailingNewlineType = "keep"
+	// TrailingNewlineStrip removes any trailing newlines.
+	TrailingNewlineStrip TrailingNewlineType = "strip"
+	// TrailingNewlineEnsure ends non-empty strings with exactly one newline.
+	TrailingNewlineEnsure TrailingNewlineType = "ensure"
+)
+
+// FormatSpec controls how the injected string is rendered.
+type FormatSpec struct {
+	// Style of the injected string in the target resources.
+	Style ScalarStyleType `yaml:"style,omitempty" json:"style,omitempty"`
+	// Indent is the indentation width of YAML and pretty JSON content. Defaults to 2.
+	Indent int `yaml:"indent,omitempty" json:"indent,omitempty"`
+	// SortKeys sorts the keys of every mapping of the content.
+	SortKeys bool `yaml:"sortKeys,omitempty" json:"sortKeys,omitempty"`
+	// TrailingNewline controls the newlines at the end of the string.
+	TrailingNewline TrailingNewlineType `yaml:"trailingNewline,omitempty" json:"trailingNewline,omitempty"`
+}
+
+// validate checks the values of the format.
+func (f *FormatSpec) validate() error {
+	if f == nil {
+		return nil
+	}
+	if _, err := f.style(); err != nil {
+		return err
+	}
+	if f.Indent != 0 && (f.Indent < 2 || f.Indent > 9) {
+		return fmt.Errorf("format indent must be between 2 and 9, got %d", f.Indent)
+	}
+	switch f.TrailingNewline {
+	case TrailingNewlineKeep, TrailingNewlineStrip, TrailingNewlineEnsure, "":
+		return nil
+	default:
+		return fmt.Errorf("unrecognized trailing newline policy: %q", f.TrailingNewline)
+	}
+}
+
+// style returns the YAML style of the injected string.
+func (f *FormatSpec) style() (yaml.Style, error) {
+	if f == nil {
+		return 0, nil
+	}
+	switch f.Style {
+	case ScalarStyleAuto, "":
+		return 0, nil
+	case ScalarStyleLiteral:
+		return yaml.LiteralStyle, nil
+	case ScalarStyleFolded:
+		return yaml.FoldedStyle, nil
+	case ScalarStyleDoubleQuoted:
+		return yaml.DoubleQuotedStyle, nil
+	case ScalarStyleSingleQuoted:
+		return yaml.SingleQuotedStyle, nil
+	default:
+		return 0, fmt.Errorf("unrecognized format style: %q", f.Style)
+	}
+}
+
+// indent returns the indentation width of the content.
+func (f *FormatSpec) indent() int {
+	if f == nil || f.Indent == 0 {
+		return yaml.DefaultIndent
+	}
+	return f.Indent
+}
+
+// scalar wraps the content into a string node of the configured style. The
+// encoder falls back to quoting content a block style cannot represent, e.g.
+// lines with trailing spaces.
+func (f *FormatSpec) scalar(content string) *yaml.RNode {
+	node := yaml.NewScalarRNode(content)
+	// Tag the content as a string, so that e.g. `8080` is not read back as a number.
+	node.YNode().Tag = yaml.NodeTagString
+	// The style is validated along with the source.
+	node.YNode().Style, _ = f.style()
+	return node
+}
+
+// trimNewlines applies the trailing newline policy to the content.
+func (f *FormatSpec) trimNewlines(content string) string {
+	if f == nil {
+		return content
+	}
+	switch f.TrailingNewline {
+	case TrailingNewlineStrip:
+		return strings.TrimRight(content, "\n")
+	case TrailingNewlineEnsure:
+		if content == "" {
+			return content
+		}
+		return strings.TrimRight(content, "\n") + "\n"
+	default:
+		return content
+	}
+}
+
+// sortKeys sorts the keys of the mappings of the node recursively.
+func sortKeys(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			sortKeys(child)
+		}
+	case yaml.MappingNode:
+		pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			sortKeys(node.Content[i+1])
+			pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+		}
+		sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0].Value < pairs[j][0].Value })
+		node.Content = node.Content[:0]
+		for _, pair := range pairs {
+			node.Content = append(node.Content, pair[0], pair[1])
+		}
+	}
+}
+
+// yamlString serializes the node like RNode.String, with the given indentation.
+func yamlString(node *yaml.RNode, indent int) (string, error) {
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(indent)
+	if err := e.Encode(node.YNode()); err != nil {
+		return "", err
+	}
+	if err := e.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
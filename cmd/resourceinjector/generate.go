@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// needsHashAnnotation asks kustomize to append a content hash to the name of a
+// generated resource and to update the references to it.
+const needsHashAnnotation = "kustomize.config.k8s.io/needs-hash"
+
+// GenerateKindType is a typed string for the kinds of generated resources.
+type GenerateKindType string
+
+// GenerateKind enumeration for the kinds of resources that can be generated.
+const (
+	// GenerateKindConfigMap generates a ConfigMap (default).
+	GenerateKindConfigMap GenerateKindType = "ConfigMap"
+	// GenerateKindSecret generates a Secret.
+	GenerateKindSecret GenerateKindType = "Secret"
+)
+
+// GenerateSpec describes a ConfigMap or Secret generated from the rendered sources.
+type GenerateSpec struct {
+	// Kind of the generated resource. Defaults to ConfigMap.
+	Kind GenerateKindType `yaml:"kind,omitempty" json:"kind,omitempty"`
+	// Name of the generated resource.
+	Name string `yaml:"name" json:"name"`
+	// Namespace of the generated resource.
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// Key the content is stored under. Optional for file sources, whose file names are used as keys.
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+	// Type of the generated Secret. Defaults to Opaque.
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// Labels of the generated resource.
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// Annotations of the generated resource.
+	Annotations map[string]string `yaml:"annotations,omitempty" json:"annotations,omitempty"`
+	// DisableNameSuffixHash disables appending a content hash to the name.
+	DisableNameSuffixHash bool `yaml:"disableNameSuffixHash,omitempty" json:"disableNameSuffixHash,omitempty"`
+	// Shard splits the source across several generated resources.
+	Shard     *ShardSpec `yaml:"shard,omitempty" json:"shard,omitempty"`
+	SourceRef `yaml:",inline" json:",inline"`
+}
+
+// generate creates the resources holding the content of the sources referred to.
+func (g *GenerateSpec) generate(rs *renderedSources) ([]*yaml.RNode, error) {
+	if g.Name == "" {
+		return nil, fmt.Errorf("name must be specified")
+	}
+	kind := g.Kind
+	if kind == "" {
+		kind = GenerateKindConfigMap
+	}
+	if kind != GenerateKindConfigMap && kind != GenerateKindSecret {
+		return nil, fmt.Errorf("unsupported kind %q, must be one of %q or %q", kind, GenerateKindConfigMap, GenerateKindSecret)
+	}
+	if g.Type != "" && kind != GenerateKindSecret {
+		return nil, fmt.Errorf("type can only be specified for Secrets")
+	}
+	if g.Shard != nil {
+		return g.generateShards(kind, rs)
+	}
+
+	setter, err := g.setter(rs)
+	if err != nil {
+		return nil, err
+	}
+	path := []string{"data"}
+	switch {
+	case g.Key != "":
+		path = append(path, g.Key)
+	case !setter.Merge:
+		return nil, fmt.Errorf("key must be specified")
+	}
+	if !setter.Merge && setter.Value.YNode().Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("structured sources cannot be stored in a %s", kind)
+	}
+
+	res, err := g.resource(kind, g.Name, !g.DisableNameSuffixHash)
+	if err != nil {
+		return nil, err
+	}
+	if err := store(res, setter, path); err != nil {
+		return nil, err
+	}
+	return []*yaml.RNode{res}, nil
+}
+
+// generateShards splits the source across several resources named after the
+// index of the shard, e.g. `name-0`, along with the resource named `name`
+// holding the index of the shards. The shards keep their names, so that the
+// index can refer to them.
+func (g *GenerateSpec) generateShards(kind GenerateKindType, rs *renderedSources) ([]*yaml.RNode, error) {
+	if len(g.Sources) > 0 {
+		return nil, fmt.Errorf("only a single source can be sharded")
+	}
+	if g.Key == "" {
+		return nil, fmt.Errorf("key must be specified")
+	}
+	inj, err := rs.get(g.Source)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := g.Shard.MaxSize
+	if maxSize == 0 {
+		maxSize = maxDataSize
+	}
+	shards, err := inj.shard(maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	generated := make([]*yaml.RNode, 0, len(shards)+1)
+	names := make([]string, len(shards))
+	for i, content := range shards {
+		names[i] = fmt.Sprintf("%s-%d", g.Name, i)
+		res, err := g.resource(kind, names[i], false)
+		if err != nil {
+			return nil, err
+		}
+		setter := &setValue{Value: inj.format.scalar(content), Base64: inj.base64, Commits: inj.commits()}
+		if err := store(res, setter, []string{"data", g.Key}); err != nil {
+			return nil, err
+		}
+		generated = append(generated, res)
+	}
+
+	index, err := shardIndex(names)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.resource(kind, g.Name, !g.DisableNameSuffixHash)
+	if err != nil {
+		return nil, err
+	}
+	indexKey := g.Key
+	if g.Shard.IndexKey != "" {
+		indexKey = g.Shard.IndexKey
+	}
+	if err := store(res, &setValue{Value: index}, []string{"data", indexKey}); err != nil {
+		return nil, err
+	}
+	return append(generated, res), nil
+}
+
+// store injects the value into the field of a generated resource.
+func store(res *yaml.RNode, setter *setValue, path []string) error {
+	field, err := res.Pipe(yaml.LookupCreate(setter.CreateKind(), path...))
+	if err != nil {
+		return err
+	}
+	return setter.Apply(&transform.Target{Resource: res, Field: field, FieldPath: path})
+}
+
+// resource creates a generated resource with the given name and the metadata of the spec.
+func (g *GenerateSpec) resource(kind GenerateKindType, name string, hash bool) (*yaml.RNode, error) {
+	res := yaml.NewMapRNode(nil)
+	res.SetApiVersion("v1")
+	res.SetKind(string(kind))
+	if err := res.SetName(name); err != nil {
+		return nil, err
+	}
+	if g.Namespace != "" {
+		if err := res.SetNamespace(g.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	if len(g.Labels) > 0 {
+		if err := res.SetLabels(g.Labels); err != nil {
+			return nil, err
+		}
+	}
+	annotations := map[string]string{}
+	for k, v := range g.Annotations {
+		annotations[k] = v
+	}
+	if hash {
+		annotations[needsHashAnnotation] = "true"
+	}
+	if len(annotations) > 0 {
+		if err := res.SetAnnotations(annotations); err != nil {
+			return nil, err
+		}
+	}
+	if kind == GenerateKindSecret {
+		secretType := g.Type
+		if secretType == "" {
+			secretType = "Opaque"
+		}
+		if err := res.PipeE(yaml.SetField("type", yaml.NewScalarRNode(secretType))); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
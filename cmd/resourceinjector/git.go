@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// gitCommitAnnotation records the commits of the git sources injected into a resource.
+const gitCommitAnnotation = "kustomize-plugins.midiparse.github.com/git-commit"
+
+// gitAllowedProtocols are the transports git may use to fetch sources. Others,
+// e.g. `ext::`, run arbitrary commands.
+const gitAllowedProtocols = "file:git:http:https:ssh"
+
+// commitRegex matches full commit SHAs.
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// GitSourceSpec is a source read from a git repository.
+type GitSourceSpec struct {
+	// Repo is the URL of the repository, or a path relative to the function config.
+	Repo string `yaml:"repo" json:"repo"`
+	// Ref is the branch or tag to render. Defaults to the HEAD of the repository.
+	Ref string `yaml:"ref,omitempty" json:"ref,omitempty"`
+	// Commit pins the full SHA of the commit to render. If ref is also set, it must resolve to this commit.
+	Commit string `yaml:"commit,omitempty" json:"commit,omitempty"`
+	// Dir is the directory within the repository to render.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// gitSource checks out the commit of the repository into the cache, where it is
+// reused by later renders, or into a temporary directory.
+func gitSource(spec *GitSourceSpec, baseDir string, cache *renderCache) (*sourceLocation, error) {
+	if spec.Repo == "" {
+		return nil, fmt.Errorf("git repo must be specified")
+	}
+	if strings.HasPrefix(spec.Repo, "-") {
+		return nil, fmt.Errorf("git repo %q must not start with '-'", spec.Repo)
+	}
+	if spec.Commit != "" && !commitRegex.MatchString(spec.Commit) {
+		return nil, fmt.Errorf("git commit %q must be a full SHA", spec.Commit)
+	}
+	repo := spec.Repo
+	if !isRepoURL(repo) {
+		abs, err := filepath.Abs(resolvePath(baseDir, repo))
+		if err != nil {
+			return nil, err
+		}
+		repo = abs
+	}
+
+	commit, err := resolveCommit(repo, spec.Ref, spec.Commit)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir string
+	cleanup := func() {}
+	if cache != nil {
+		// Checkouts of a commit never change, so they are shared.
+		sum := sha256.Sum256([]byte(repo))
+		dir = filepath.Join(cache.dir, gitCacheDir, hex.EncodeToString(sum[:8])+"-"+commit)
+		if _, err := os.Stat(dir); err == nil && !isCheckoutOf(dir, commit) {
+			// The checkout was tampered with or left behind broken, so it is replaced.
+			if err := os.RemoveAll(dir); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := os.Stat(dir); err != nil {
+			if err := checkoutToCache(repo, commit, dir); err != nil {
+				return nil, err
+			}
+		}
+		touch(dir)
+		if err := cache.evict(); err != nil {
+			return nil, fmt.Errorf("failed to evict from render cache: %w", err)
+		}
+	} else {
+		tmp, err := os.MkdirTemp("", "resourceinjector-git-")
+		if err != nil {
+			return nil, err
+		}
+		cleanup = func() { os.RemoveAll(tmp) }
+		dir = filepath.Join(tmp, "checkout")
+		if err := checkout(repo, commit, dir); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+
+	sourcePath, err := subPath(dir, spec.Dir)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	return &sourceLocation{
+		fSys:    filesys.MakeFsOnDisk(),
+		path:    sourcePath,
+		onDisk:  true,
+		commit:  commit,
+		origin:  remoteOrigin(repo, commit, spec.Dir),
+		cleanup: cleanup,
+	}, nil
+}
+
+// isRepoURL reports whether the repo is a URL rather than a local path. URLs,
+// including the `<transport>::<address>` syntax, are left to git, which only
+// allows gitAllowedProtocols.
+func isRepoURL(repo string) bool {
+	return strings.Contains(repo, "://") || strings.Contains(repo, "::") || strings.HasPrefix(repo, "git@")
+}
+
+// resolveCommit resolves the ref of the repository to a commit, checking it
+// against the pinned commit, if any.
+func resolveCommit(repo, ref, pinned string) (string, error) {
+	if ref == "" && pinned != "" {
+		return pinned, nil
+	}
+	if commitRegex.MatchString(ref) {
+		return "", fmt.Errorf("git ref %q is a commit, use commit to pin it", ref)
+	}
+	pattern := ref
+	if pattern == "" {
+		pattern = "HEAD"
+	}
+	// Peeled tags are only listed when asked for explicitly.
+	out, err := runGit("", "ls-remote", "--", repo, pattern, pattern+"^{}")
+	if err != nil {
+		return "", err
+	}
+
+	// Annotated tags are listed along with the commit they point to, suffixed with ^{}.
+	commits := map[string]string{}
+	peeled := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		sha, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		base, isPeeled := strings.CutSuffix(name, "^{}")
+		// ls-remote matches any ref ending with the pattern, only exact branches and tags are considered.
+		if base != pattern && base != "refs/heads/"+pattern && base != "refs/tags/"+pattern {
+			continue
+		}
+		if isPeeled {
+			commits[base], peeled[base] = sha, true
+		} else if !peeled[base] {
+			commits[base] = sha
+		}
+	}
+	if len(commits) == 0 {
+		return "", fmt.Errorf("git ref %q not found in %s", pattern, repo)
+	}
+	var resolved string
+	names := map[string]bool{}
+	ambiguous := false
+	for name, sha := range commits {
+		names[name] = true
+		ambiguous = ambiguous || (resolved != "" && resolved != sha)
+		resolved = sha
+	}
+	if ambiguous {
+		return "", fmt.Errorf("git ref %q is ambiguous in %s: %s", pattern, repo, strings.Join(sortedKeys(names), ", "))
+	}
+	if pinned != "" && resolved != pinned {
+		return "", fmt.Errorf("git ref %q resolves to %s, not the pinned commit %s", pattern, resolved, pinned)
+	}
+	return resolved, nil
+}
+
+// checkoutToCache checks out the commit next to the cached checkout, then moves
+// it in place, so that concurrent renders never see a partial checkout.
+func checkoutToCache(repo, commit, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := checkout(repo, commit, filepath.Join(tmp, "checkout")); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(tmp, "checkout"), dir); err != nil && !os.IsExist(err) {
+		// Another render may have checked out the same commit in the meantime.
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isCheckoutOf reports whether the HEAD of the checkout in dir is the commit.
+func isCheckoutOf(dir, commit string) bool {
+	// The git dir is passed explicitly, so that git does not look for a repository above dir.
+	head, err := runGit("", "--git-dir", filepath.Join(dir, ".git"), "rev-parse", "--verify", "HEAD")
+	return err == nil && strings.TrimSpace(head) == commit
+}
+
+// checkout clones the repository into dir and checks out the commit.
+func checkout(repo, commit, dir string) error {
+	if _, err := runGit("", "clone", "--quiet", "--no-checkout", "--", repo, dir); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "checkout", "--quiet", "--detach", commit); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runGit runs git non-interactively and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+gitAllowedProtocols)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// commits returns the commit the injection is rendered from, if any.
+func (inj *injection) commits() []string {
+	if inj.commit == "" {
+		return nil
+	}
+	return []string{inj.commit}
+}
+
+// annotateCommits records the commits of the git sources injected into the
+// resource, as a comma separated list, keeping those of earlier injections.
+func annotateCommits(resource *yaml.RNode, commits []string) error {
+	if len(commits) == 0 {
+		return nil
+	}
+	var recorded []string
+	if existing := resource.GetAnnotations()[gitCommitAnnotation]; existing != "" {
+		recorded = strings.Split(existing, ",")
+	}
+	for _, commit := range commits {
+		if !slices.Contains(recorded, commit) {
+			recorded = append(recorded, commit)
+		}
+	}
+	return resource.PipeE(yaml.SetAnnotation(gitCommitAnnotation, strings.Join(recorded, ",")))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// defaultHelmCommand is the helm executable used when none is configured.
+const defaultHelmCommand = "helm"
+
+var (
+	// kubeVersionRegex matches the Kubernetes versions helm accepts, e.g. `1.29` or `v1.29.1`.
+	kubeVersionRegex = regexp.MustCompile(`^v?\d+\.\d+(\.\d+)?(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+	// apiVersionRegex matches api versions with an optional group and kind, e.g. `v1` or `apps/v1/Deployment`.
+	apiVersionRegex = regexp.MustCompile(`^([a-z0-9.-]+/)?v\d+[a-z0-9]*(/[A-Za-z0-9]+)?$`)
+)
+
+// parseHelmConfig validates the helm configuration and converts it to
+// ktypes.HelmConfig. Commands containing a path separator are resolved
+// relative to baseDir. The command itself is only looked up when a chart is
+// inflated, so sources without charts build without helm installed.
+func parseHelmConfig(c *HelmConfig, baseDir string) (ktypes.HelmConfig, error) {
+	if !c.Enabled {
+		if c.Command != "" || len(c.ApiVersions) > 0 || c.KubeVersion != "" || c.Debug {
+			return ktypes.HelmConfig{}, fmt.Errorf("helmConfig.enabled must be true to configure helm")
+		}
+		return ktypes.HelmConfig{}, nil
+	}
+
+	command := c.Command
+	if command == "" {
+		command = defaultHelmCommand
+	}
+	if strings.ContainsRune(command, filepath.Separator) {
+		abs, err := filepath.Abs(resolvePath(baseDir, command))
+		if err != nil {
+			return ktypes.HelmConfig{}, err
+		}
+		command = abs
+	}
+
+	if c.KubeVersion != "" && !kubeVersionRegex.MatchString(c.KubeVersion) {
+		return ktypes.HelmConfig{}, fmt.Errorf("invalid helmConfig.kubeVersion %q, must be a version like 1.29.0", c.KubeVersion)
+	}
+	for _, apiVersion := range c.ApiVersions {
+		if !apiVersionRegex.MatchString(apiVersion) {
+			return ktypes.HelmConfig{}, fmt.Errorf(
+				"invalid helmConfig.apiVersions entry %q, must be an api version like apps/v1 or apps/v1/Deployment", apiVersion)
+		}
+	}
+
+	return ktypes.HelmConfig{
+		Enabled:     true,
+		Command:     command,
+		ApiVersions: c.ApiVersions,
+		KubeVersion: c.KubeVersion,
+		Debug:       c.Debug,
+	}, nil
+}
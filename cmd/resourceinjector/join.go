@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// SourceJoinType is a typed string for the ways multiple rendered resources are combined.
+type SourceJoinType string
+
+// SourceJoin enumeration for combining rendered resources.
+const (
+	// SourceJoinDocuments keeps the resources as separate YAML documents (`---` separated).
+	SourceJoinDocuments SourceJoinType = "documents"
+	// SourceJoinSequence combines the resources into a YAML sequence.
+	SourceJoinSequence SourceJoinType = "sequence"
+	// SourceJoinList wraps the resources into a `kind: List` resource.
+	SourceJoinList SourceJoinType = "list"
+)
+
+// joinSource combines the rendered resources into the documents to be injected.
+// Without an explicit join mode the resources are kept as documents.
+func joinSource(resources []*yaml.RNode, join SourceJoinType) ([]*yaml.RNode, error) {
+	switch join {
+	case SourceJoinDocuments, "":
+		return resources, nil
+	case SourceJoinSequence:
+		return []*yaml.RNode{sequenceOf(resources)}, nil
+	case SourceJoinList:
+		list := yaml.NewMapRNode(nil)
+		if err := list.PipeE(yaml.SetField(yaml.APIVersionField, yaml.NewStringRNode("v1"))); err != nil {
+			return nil, err
+		}
+		if err := list.PipeE(yaml.SetField(yaml.KindField, yaml.NewStringRNode("List"))); err != nil {
+			return nil, err
+		}
+		if err := list.PipeE(yaml.SetField("items", sequenceOf(resources))); err != nil {
+			return nil, err
+		}
+		return []*yaml.RNode{list}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized source join: %q", join)
+	}
+}
+
+func sequenceOf(resources []*yaml.RNode) *yaml.RNode {
+	seq := yaml.NewListRNode()
+	for _, res := range resources {
+		seq.YNode().Content = append(seq.YNode().Content, res.YNode())
+	}
+	return seq
+}
+
+// documentSeparator separates the documents of a multi-document YAML string.
+const documentSeparator = "---\n"
+
+// documentsString serializes the documents into a multi-document YAML string.
+// A single string document, e.g. a projected ConfigMap key, is used as is.
+func documentsString(docs []*yaml.RNode, indent int) (string, error) {
+	if len(docs) == 1 && docs[0].YNode().Kind == yaml.ScalarNode && docs[0].YNode().Tag == yaml.NodeTagString {
+		return docs[0].YNode().Value, nil
+	}
+	parts := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		s, err := yamlString(doc, indent)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, documentSeparator), nil
+}
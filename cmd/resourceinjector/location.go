@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// memSourceRoot is the directory archives and OCI artifacts are unpacked into
+// in memory. It is not the root directory, so that inline kustomizations can
+// be placed next to it.
+const memSourceRoot = "/source"
+
+// sourceLocation is the file system and path a source is rendered from.
+type sourceLocation struct {
+	fSys filesys.FileSystem
+	path string
+	// onDisk is set when the file system is the one on disk, rather than in memory.
+	onDisk bool
+	// identity distinguishes the content of sources unpacked in memory in the render cache.
+	identity string
+	// commit is the resolved commit of git sources.
+	commit string
+	// origin identifies remote sources in the chain of nested injectors. Sources
+	// unpacked in memory all share the same path, so they are told apart by
+	// where they were fetched from and the version resolved.
+	origin string
+	// cleanup removes any temporary files of the source.
+	cleanup func()
+}
+
+// isRemote reports whether the source is read from a git repository, an archive, an OCI image layout or a URL.
+func (s *SourceSpec) isRemote() bool {
+	return s.Git != nil || s.Archive != nil || s.OCI != nil || s.URL != ""
+}
+
+// localPath returns the path on disk the source is read from, if any. It is
+// subject to the load restrictions.
+func (s *SourceSpec) localPath(baseDir string) string {
+	switch {
+	case s.Path != "":
+		return resolvePath(baseDir, s.Path)
+	case s.Archive != nil:
+		return resolvePath(baseDir, s.Archive.Path)
+	case s.OCI != nil:
+		return resolvePath(baseDir, s.OCI.Path)
+	case s.Git != nil:
+		if p, ok := strings.CutPrefix(s.Git.Repo, "file://"); ok {
+			return p
+		}
+		if !isRepoURL(s.Git.Repo) {
+			return resolvePath(baseDir, s.Git.Repo)
+		}
+	}
+	return ""
+}
+
+// locateSource returns where the source is rendered from, fetching or
+// unpacking it first if needed. Local paths are checked against the load
+// restrictions before anything is read.
+func locateSource(source *SourceSpec, baseDir string, restrictions LoadRestrictionsType) (*sourceLocation, error) {
+	specified := 0
+	for _, set := range []bool{source.Path != "", source.Git != nil, source.Archive != nil, source.OCI != nil, source.URL != ""} {
+		if set {
+			specified++
+		}
+	}
+	if specified != 1 {
+		return nil, fmt.Errorf("exactly one of path, git, archive, oci or url must be specified")
+	}
+	if source.URL == "" && (source.SHA256 != "" || source.Fetch != nil) {
+		return nil, fmt.Errorf("sha256 and fetch can only be used with url")
+	}
+	if path := source.localPath(baseDir); restrictions == LoadRestrictionsRootOnly && path != "" {
+		if err := checkRootOnly(baseDir, path); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case source.Git != nil:
+		cache, err := sourceCache(source.Cache, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		return gitSource(source.Git, baseDir, cache)
+	case source.Archive != nil:
+		return archiveSource(source.Archive, baseDir)
+	case source.OCI != nil:
+		return ociSource(source.OCI, baseDir)
+	case source.URL != "":
+		cache, err := sourceCache(source.Cache, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		return urlSource(source, cache)
+	}
+	sourcePath := resolvePath(baseDir, source.Path)
+	return &sourceLocation{
+		fSys:    filesys.MakeFsOnDisk(),
+		path:    sourcePath,
+		onDisk:  true,
+		cleanup: func() {},
+	}, nil
+}
+
+// remoteOrigin identifies a remote source by its location, the version
+// resolved and the directory rendered, e.g. `https://example.com/app.tgz@sha256:<hex>//app`.
+func remoteOrigin(location, version, dir string) string {
+	origin := location + "@" + version
+	if dir != "" {
+		origin += "//" + path.Clean(dir)
+	}
+	return origin
+}
+
+// chainEntry returns the entry of the source in the chain of nested injectors.
+func (l *sourceLocation) chainEntry() (string, error) {
+	if l.origin != "" {
+		return l.origin, nil
+	}
+	return filepath.Abs(l.path)
+}
+
+// subPath joins a relative directory to the root of a fetched or unpacked source,
+// rejecting directories outside of it.
+func subPath(root, dir string) (string, error) {
+	if dir == "" {
+		return root, nil
+	}
+	cleaned := path.Clean(filepath.ToSlash(dir))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("dir %q must be relative to the root of the source", dir)
+	}
+	return filepath.Join(root, filepath.FromSlash(cleaned)), nil
+}
+
+// verifyDigest checks the content against a digest in the `sha256:<hex>` format.
+func verifyDigest(content []byte, digest string) error {
+	algorithm, expected, ok := strings.Cut(digest, ":")
+	if !ok || algorithm != "sha256" {
+		return fmt.Errorf("unsupported digest %q, must be in the sha256:<hex> format", digest)
+	}
+	if actual := sha256Digest(content); actual != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", "sha256:"+expected, actual)
+	}
+	return nil
+}
+
+// sha256Digest returns the digest of the content in the `sha256:<hex>` format.
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 
 	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"github.com/midiparse/kustomize-plugins/internal/yq"
+	"github.com/spf13/cobra"
 	"sigs.k8s.io/kustomize/api/krusty"
 	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework/command"
+	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
 
 func main() {
+	// Configure yq logging - suppress debug messages unless DEBUG env var is set
+	yq.ConfigureLogging()
+
 	api := &API{}
 
 	// Use the kyaml framework to build a command-line tool.
@@ -22,6 +29,12 @@ func main() {
 		command.StandaloneEnabled,
 		false,
 	)
+	// In standalone mode the first argument is the function config file.
+	cmd.PreRun = func(_ *cobra.Command, args []string) {
+		if len(args) > 0 {
+			api.configFile = args[0]
+		}
+	}
 	command.AddGenerateDockerfile(cmd)
 
 	if err := cmd.Execute(); err != nil {
@@ -34,92 +47,328 @@ type API struct {
 	Metadata struct {
 		// Name is the Deployment Resource and Container name
 		Name string `yaml:"name"`
+		// Annotations of the function config, used to locate it on disk.
+		Annotations map[string]string `yaml:"annotations,omitempty"`
 	} `yaml:"metadata"`
 	Spec ResourceInjectorSpec `yaml:"spec" json:"spec"`
+
+	// configFile is the function config file passed in standalone mode.
+	configFile string
 }
 
 type setValue struct {
 	Value *yaml.RNode
+	// Base64 is set when the value is already base64 encoded.
+	Base64 bool
+	// Merge sets the fields of a mapping value individually instead of replacing the target.
+	Merge bool
+	// Options of the target, if any.
+	Options *TargetOptions
+	// Commits of the git sources of the value, recorded on the target resource.
+	Commits []string
 }
 
 func (s *setValue) CreateKind() yaml.Kind {
 	return s.Value.YNode().Kind
 }
 
-func (s *setValue) Apply(target *yaml.RNode) error {
+func (s *setValue) Apply(t *transform.Target) error {
 	value := s.Value.Copy()
+	target := t.Field
 
-	if target.YNode().Kind == yaml.ScalarNode {
+	if err := encodeSecretValue(t, value, s.Base64, s.Options); err != nil {
+		return err
+	}
+
+	strategy := mergeStrategy(s.Options)
+	if strategy != MergeReplace {
+		if err := mergeValue(t, value, strategy); err != nil {
+			return err
+		}
+	} else if s.Merge && target.YNode().Kind == yaml.MappingNode && value.YNode().Kind == yaml.MappingNode {
+		err := value.VisitFields(func(node *yaml.MapNode) error {
+			return target.PipeE(yaml.SetField(node.Key.YNode().Value, node.Value))
+		})
+		if err != nil {
+			return err
+		}
+	} else if target.YNode().Kind == yaml.ScalarNode && value.YNode().Kind == yaml.ScalarNode {
 		// For scalar, only copy the value (leave any type intact to auto-convert int->string or string->int)
 		target.YNode().Value = value.YNode().Value
+		// Fields created for the value have no type yet, so they take that of the value.
+		if target.YNode().Tag == "" {
+			target.YNode().Tag = value.YNode().Tag
+		}
+		if value.YNode().Style != 0 {
+			target.YNode().Style = value.YNode().Style
+		}
 	} else {
 		target.SetYNode(value.YNode())
 	}
 
-	return nil
+	if err := annotateCommits(t.Resource, s.Commits); err != nil {
+		return err
+	}
+	return validateSize(t)
 }
 
-// Filter reads the source, builds it if necessary, and injects the result
+// Filter reads the sources, builds them if necessary, and injects the results
 // into the target resources.
 func (r *API) Filter(items []*yaml.RNode) ([]*yaml.RNode, error) {
-	if r.Spec.Source == nil || r.Spec.Source.Path == "" {
-		return nil, fmt.Errorf("source.path must be specified")
+	entries, err := r.matrixEntries()
+	if err != nil {
+		return nil, err
 	}
 
-	// 1. Render the source content.
-	source, err := kustomizeSource(r.Spec.Source)
+	// Sources only see the input, not the resources generated by earlier entries.
+	input := items
+	var values, removed []*yaml.RNode
+	for _, entry := range entries {
+		// 1. Render every source once.
+		rendered, err := r.renderSources(entry, input)
+		if err != nil {
+			return nil, entry.wrap(err)
+		}
+		removed = append(removed, rendered.removed...)
+
+		// 2. Generate the resources holding the rendered content.
+		if r.Spec.Generate != nil {
+			spec, err := entry.generateSpec(r.Spec.Generate)
+			if err != nil {
+				return nil, entry.wrap(err)
+			}
+			generated, err := spec.generate(rendered)
+			if err != nil {
+				return nil, entry.wrap(fmt.Errorf("generate: %w", err))
+			}
+			items = append(items, generated...)
+		}
+
+		// 3. Inject the rendered content into the targets.
+		for _, target := range r.Spec.Targets {
+			target, err := entry.targetSpec(target)
+			if err != nil {
+				return nil, entry.wrap(err)
+			}
+			items, err = target.apply(items, rendered)
+			if err != nil {
+				return nil, entry.wrap(err)
+			}
+		}
+		values = append(values, rendered.values()...)
+	}
+
+	// 4. Drop the resources of the stream that were rendered as sources.
+	items = withoutResources(items, removed)
+
+	// 5. Annotate dependents with the checksum of the injected content.
+	if r.Spec.Checksum != nil {
+		items, err = applyChecksum(items, values, r.Spec.Checksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply checksum: %w", err)
+		}
+	}
+
+	return items, nil
+}
+
+// renderSource renders a single source into the content to be injected.
+func renderSource(source *SourceSpec, baseDir string) (*injection, error) {
+	if source.Path == "" && !source.isRemote() && source.FromStream == nil {
+		return nil, fmt.Errorf("path must be specified")
+	}
+	if err := source.Format.validate(); err != nil {
+		return nil, err
+	}
+	if source.Raw {
+		return rawSource(source, baseDir)
+	}
+
+	// 1. Render the source content, or take it from the stream.
+	var resources []*yaml.RNode
+	var commit string
+	var err error
+	if source.FromStream != nil {
+		resources, err = streamSource(source)
+	} else {
+		resources, commit, err = kustomizeSource(source, baseDir)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to render source: %w", err)
 	}
 
-	if r.Spec.Source.FieldPath != "" {
-		var err error
-		source, err = source.Pipe(yaml.Lookup(r.Spec.Source.FieldPath))
+	// 2. Pick and project the rendered resources.
+	if source.Select != nil {
+		resources, err = transform.Select(resources, source.Select)
 		if err != nil {
-			return nil, fmt.Errorf("failed to lookup field path in rendered source: %w", err)
+			return nil, fmt.Errorf("failed to select resources from rendered source: %w", err)
 		}
-		if source == nil {
-			return nil, fmt.Errorf("field path %q not found in rendered source", r.Spec.Source.FieldPath)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no resources selected from rendered source")
+	}
+
+	if fieldPaths := source.fieldPaths(); len(fieldPaths) > 0 {
+		for i, res := range resources {
+			projected, err := projectFieldPaths(res, fieldPaths)
+			if err != nil {
+				return nil, err
+			}
+			resources[i] = projected
 		}
 	}
 
-	// We wrap it in a string node as the value needs to be injected as a string.
-	sourceContent, err := source.String()
+	// 3. Join and reshape the resources, then convert them into the injected value.
+	docs, err := joinSource(resources, source.Join)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert source to string: %w", err)
+		return nil, err
 	}
-	setter := setValue{Value: yaml.NewScalarRNode(sourceContent)}
-
-	items, err = transform.Apply(&setter, items, r.Spec.Targets)
+	if source.Expression != "" {
+		evaluator := yq.NewEvaluator(source.Expression, nil)
+		for i, doc := range docs {
+			out, err := evaluator.Evaluate(doc.YNode())
+			if err != nil {
+				return nil, fmt.Errorf("failed to apply expression to rendered source: %w", err)
+			}
+			docs[i] = yaml.NewRNode(out)
+		}
+	}
+	if source.Format != nil && source.Format.SortKeys {
+		for _, doc := range docs {
+			sortKeys(doc.YNode())
+		}
+	}
+	value, err := sourceValue(docs, source)
 	if err != nil {
-		return nil, fmt.Errorf("failed to apply replacements: %w", err)
+		return nil, err
 	}
+	inj := &injection{
+		value:  value,
+		base64: source.Encoding == SourceEncodingBase64 || source.Encoding == SourceEncodingGzipBase64,
+		commit: commit,
+	}
+	if source.Mode != SourceModeStructured {
+		inj.documents = docs
+		inj.encoding = source.Encoding
+		inj.format = source.Format
+	}
+	return inj, nil
+}
 
-	return items, nil
+// sourceValue converts the joined source documents into the node to be injected
+// according to the injection mode and encoding.
+func sourceValue(docs []*yaml.RNode, source *SourceSpec) (*yaml.RNode, error) {
+	switch source.Mode {
+	case SourceModeStructured:
+		if len(docs) != 1 {
+			return nil, fmt.Errorf("structured mode requires a single document, got %d: use join %q or %q",
+				len(docs), SourceJoinSequence, SourceJoinList)
+		}
+		if source.Encoding != "" && source.Encoding != SourceEncodingYAML {
+			return nil, fmt.Errorf("encoding %q cannot be used in structured mode", source.Encoding)
+		}
+		if source.Format != nil {
+			return nil, fmt.Errorf("format cannot be used in structured mode")
+		}
+		// Splice the rendered node tree as is.
+		return docs[0], nil
+	case SourceModeString, "":
+		// We wrap it in a string node as the value needs to be injected as a string.
+		sourceContent, err := encodeSource(docs, source.Encoding, source.Format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode source: %w", err)
+		}
+		return source.Format.scalar(sourceContent), nil
+	default:
+		return nil, fmt.Errorf("unrecognized source mode: %q", source.Mode)
+	}
 }
 
 // ResourceInjectorSpec defines the configuration for the resource injector.
 type ResourceInjectorSpec struct {
-	Source  *SourceSpec                 `yaml:"source,omitempty" json:"source,omitempty"`
-	Targets []*transform.TargetSelector `json:"targets,omitempty" yaml:"targets,omitempty"`
+	// Source is the default source of the targets.
+	Source *SourceSpec `yaml:"source,omitempty" json:"source,omitempty"`
+	// Sources are additional sources the targets can refer to by name.
+	Sources []*NamedSourceSpec `yaml:"sources,omitempty" json:"sources,omitempty"`
+	Targets []*TargetSpec      `json:"targets,omitempty" yaml:"targets,omitempty"`
+	// Optional ConfigMap or Secret generated from the sources.
+	Generate *GenerateSpec `yaml:"generate,omitempty" json:"generate,omitempty"`
+	// Optional checksum of the injected content written into other resources.
+	Checksum *ChecksumSpec `yaml:"checksum,omitempty" json:"checksum,omitempty"`
+	// Optional parameter sets the sources are rendered with, once per set.
+	Matrix []*MatrixEntrySpec `yaml:"matrix,omitempty" json:"matrix,omitempty"`
 }
 
 // SourceSpec defines the source of the content to be injected.
 type SourceSpec struct {
 	// Path to the kustomization directory.
-	Path string `yaml:"path" json:"path"`
+	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// Git repository to render instead of a path.
+	Git *GitSourceSpec `yaml:"git,omitempty" json:"git,omitempty"`
+	// Archive to render instead of a path.
+	Archive *ArchiveSourceSpec `yaml:"archive,omitempty" json:"archive,omitempty"`
+	// OCI image layout to render instead of a path.
+	OCI *OCISourceSpec `yaml:"oci,omitempty" json:"oci,omitempty"`
+	// URL of a file or archive to fetch and render instead of a path.
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+	// SHA256 is the hex encoded digest the content fetched from URL is verified against.
+	SHA256 string `yaml:"sha256,omitempty" json:"sha256,omitempty"`
+	// Fetch configures fetching the URL.
+	Fetch *FetchSpec `yaml:"fetch,omitempty" json:"fetch,omitempty"`
+	// FromStream renders resources of the stream instead of a path.
+	FromStream *FromStreamSpec `yaml:"fromStream,omitempty" json:"fromStream,omitempty"`
+	// Raw injects the file content verbatim, without parsing it.
+	Raw bool `yaml:"raw,omitempty" json:"raw,omitempty"`
+	// Optional selector picking which rendered resources are injected.
+	Select *ktypes.Selector `yaml:"select,omitempty" json:"select,omitempty"`
 	// Optional field path to extract from the rendered source.
 	FieldPath string `yaml:"fieldPath,omitempty" json:"fieldPath,omitempty"`
+	// Optional field paths to extract from the rendered source and merge into one mapping.
+	FieldPaths []string `yaml:"fieldPaths,omitempty" json:"fieldPaths,omitempty"`
+	// Optional yq expression reshaping each joined document.
+	Expression string `yaml:"expression,omitempty" json:"expression,omitempty"`
+	// Mode controls whether the source is injected as a string or as structured YAML.
+	Mode SourceModeType `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Join controls how multiple rendered resources are combined.
+	Join SourceJoinType `yaml:"join,omitempty" json:"join,omitempty"`
+	// Encoding of the injected string.
+	Encoding SourceEncodingType `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	// Format controls how the injected string is rendered.
+	Format *FormatSpec `yaml:"format,omitempty" json:"format,omitempty"`
+	// Substitute replaces variable placeholders in the rendered source.
+	Substitute *SubstituteSpec `yaml:"substitute,omitempty" json:"substitute,omitempty"`
+	// Optional inline kustomization layered over the source.
+	Kustomization *ktypes.Kustomization `yaml:"kustomization,omitempty" json:"kustomization,omitempty"`
 	// Optional kustomize options applied when rendering directories.
 	Options *SourceOptions `yaml:"options,omitempty" json:"options,omitempty"`
+	// Optional on-disk cache of the rendered source.
+	Cache *CacheSpec `yaml:"cache,omitempty" json:"cache,omitempty"`
+	// Optional values of each target pushed into the source build, which is rendered per target.
+	Parameters []*ParameterSpec `yaml:"parameters,omitempty" json:"parameters,omitempty"`
+
+	// streamed are the resources selected by FromStream.
+	streamed []*yaml.RNode
 }
 
+// SourceModeType is a typed string for source injection modes.
+type SourceModeType string
+
+// SourceMode enumeration for the ways the rendered source can be injected.
+const (
+	// SourceModeString injects the rendered source as a YAML string (default).
+	SourceModeString SourceModeType = "string"
+	// SourceModeStructured injects the rendered node tree into the target field.
+	SourceModeStructured SourceModeType = "structured"
+)
+
 // SourceOptions allows fine-tuning of the kustomize run for the source.
 type SourceOptions struct {
 	Reorder           krusty.ReorderOption `yaml:"reorder,omitempty" json:"reorder,omitempty"`
 	AddManagedByLabel bool                 `yaml:"addManagedByLabel,omitempty" json:"addManagedByLabel,omitempty"`
 	LoadRestrictions  LoadRestrictionsType `yaml:"loadRestrictions,omitempty" json:"loadRestrictions,omitempty"`
 	PluginConfig      *PluginConfig        `yaml:"pluginConfig,omitempty" json:"pluginConfig,omitempty"`
+	// MaxDepth limits how deep injectors rendering sources with other injectors may be nested.
+	MaxDepth int `yaml:"maxDepth,omitempty" json:"maxDepth,omitempty"`
 }
 
 // PluginConfig defines plugin-related configuration.
@@ -136,6 +385,14 @@ type PluginConfig struct {
 
 type HelmConfig struct {
 	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// Command is the helm executable, looked up in PATH or relative to the function config. Defaults to helm.
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	// ApiVersions are the Kubernetes api versions used for Capabilities.APIVersions.
+	ApiVersions []string `yaml:"apiVersions,omitempty" json:"apiVersions,omitempty"`
+	// KubeVersion is the Kubernetes version used for Capabilities.KubeVersion.
+	KubeVersion string `yaml:"kubeVersion,omitempty" json:"kubeVersion,omitempty"`
+	// Debug enables verbose helm output.
+	Debug bool `yaml:"debug,omitempty" json:"debug,omitempty"`
 }
 
 type FnPluginLoadingOptions struct {
@@ -197,7 +454,7 @@ func parsePluginRestrictions(s PluginRestrictionsType) (ktypes.PluginRestriction
 	}
 }
 
-func applySourceOptions(opts *krusty.Options, sourceOpts *SourceOptions) error {
+func applySourceOptions(opts *krusty.Options, sourceOpts *SourceOptions, baseDir string) error {
 	if sourceOpts == nil {
 		return nil
 	}
@@ -219,41 +476,105 @@ func applySourceOptions(opts *krusty.Options, sourceOpts *SourceOptions) error {
 		if err != nil {
 			return err
 		}
+		hc, err := parseHelmConfig(&sourceOpts.PluginConfig.HelmConfig, baseDir)
+		if err != nil {
+			return err
+		}
 		opts.PluginConfig = &ktypes.PluginConfig{
 			PluginRestrictions: pr,
 			FnpLoadingOptions: ktypes.FnPluginLoadingOptions{
 				EnableExec: sourceOpts.PluginConfig.FnpLoadingOptions.EnableExec,
 			},
-			HelmConfig: ktypes.HelmConfig{
-				Enabled: sourceOpts.PluginConfig.HelmConfig.Enabled,
-			},
+			HelmConfig: hc,
 		}
 	}
 	return nil
 }
 
-// kustomizeSource renders a SourceSpec and returns the content as a structured yaml node.
-func kustomizeSource(source *SourceSpec) (*yaml.RNode, error) {
-	fSys := filesys.MakeFsOnDisk()
-	sourcePath := source.Path
+// kustomizeSource renders a SourceSpec and returns the content as a list of structured yaml nodes,
+// along with the resolved commit of git sources. Relative source paths are resolved against baseDir.
+func kustomizeSource(source *SourceSpec, baseDir string) ([]*yaml.RNode, string, error) {
+	restrictions, err := sourceLoadRestrictions(source.Options)
+	if err != nil {
+		return nil, "", err
+	}
+	loc, err := locateSource(source, baseDir, restrictions)
+	if err != nil {
+		return nil, "", err
+	}
+	defer loc.cleanup()
+
+	nodes, err := renderLocation(source, baseDir, loc, restrictions)
+	if err != nil {
+		return nil, "", err
+	}
+	return nodes, loc.commit, nil
+}
+
+// renderLocation renders the source from the located directory or file.
+func renderLocation(
+	source *SourceSpec, baseDir string, loc *sourceLocation, restrictions LoadRestrictionsType,
+) ([]*yaml.RNode, error) {
+	fSys, sourcePath := loc.fSys, loc.path
+
+	// Check if the path is a directory, or has to be built with inline overrides.
+	if fSys.IsDir(sourcePath) || source.Kustomization != nil {
+		// Nested injectors may render this source again, so keep track of the chain.
+		chain, err := currentSourceChain()
+		if err != nil {
+			return nil, err
+		}
+		var maxDepth int
+		if source.Options != nil {
+			maxDepth = source.Options.MaxDepth
+		}
+		entry, err := loc.chainEntry()
+		if err != nil {
+			return nil, err
+		}
+		chain, err = chain.enter(entry, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		restoreEnv, err := chain.setenv()
+		if err != nil {
+			return nil, err
+		}
+		defer restoreEnv()
+
+		buildPath := sourcePath
+		if source.Kustomization != nil {
+			fSys, buildPath, err = overlayKustomization(fSys, sourcePath, source.Kustomization, loc.onDisk)
+			if err != nil {
+				return nil, fmt.Errorf("failed to apply inline kustomization: %w", err)
+			}
+		}
 
-	// Check if the path is a directory
-	if fSys.IsDir(sourcePath) {
 		// Treat as a kustomization directory and build it.
 		opts := krusty.MakeDefaultOptions()
-		if err := applySourceOptions(opts, source.Options); err != nil {
+		if err := applySourceOptions(opts, source.Options, baseDir); err != nil {
 			return nil, fmt.Errorf("failed to apply source options: %w", err)
 		}
-		k := krusty.MakeKustomizer(opts)
-		resMap, err := k.Run(fSys, sourcePath)
+		if restrictions == LoadRestrictionsRootOnly {
+			opts.LoadRestrictions = ktypes.LoadRestrictionsRootOnly
+		}
+		cache, err := sourceCache(source.Cache, baseDir)
 		if err != nil {
-			return nil, fmt.Errorf("kustomize build failed for %q: %w", sourcePath, err)
+			return nil, err
+		}
+		var yamlBytes []byte
+		if cache != nil {
+			yamlBytes, err = cache.build(fSys, buildPath, opts, source.Kustomization, loc.identity)
+		} else {
+			yamlBytes, err = build(fSys, buildPath, opts)
 		}
-		yamlBytes, err := resMap.AsYaml()
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal kustomize output to YAML: %w", err)
+			return nil, fmt.Errorf("kustomize build failed for %q: %w", sourcePath, err)
+		}
+		if yamlBytes, err = source.Substitute.apply(yamlBytes); err != nil {
+			return nil, err
 		}
-		return yaml.Parse(string(yamlBytes))
+		return parseDocuments(yamlBytes)
 	}
 
 	// If not a kustomization, treat it as a plain file.
@@ -261,6 +582,38 @@ func kustomizeSource(source *SourceSpec) (*yaml.RNode, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read source file %q: %w", sourcePath, err)
 	}
+	if content, err = source.Substitute.apply(content); err != nil {
+		return nil, err
+	}
+
+	nodes, err := parseDocuments(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source file %q: %w", sourcePath, err)
+	}
+	return nodes, nil
+}
+
+// build runs kustomize on the build path and returns the output as YAML.
+func build(fSys filesys.FileSystem, buildPath string, opts *krusty.Options) ([]byte, error) {
+	resMap, err := krusty.MakeKustomizer(opts).Run(fSys, buildPath)
+	if err != nil {
+		return nil, err
+	}
+	yamlBytes, err := resMap.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal kustomize output to YAML: %w", err)
+	}
+	return yamlBytes, nil
+}
 
-	return yaml.Parse(string(content))
+// parseDocuments parses every document of a multi-document YAML stream.
+// Documents are not necessarily resources, so lists are not unwrapped and no
+// reader annotations are added.
+func parseDocuments(content []byte) ([]*yaml.RNode, error) {
+	reader := &kio.ByteReader{
+		Reader:                bytes.NewReader(content),
+		OmitReaderAnnotations: true,
+		DisableUnwrapping:     true,
+	}
+	return reader.Read()
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	ktypes "sigs.k8s.io/kustomize/api/types"
+)
+
+// MatrixEntrySpec is a set of parameters the sources are rendered with. The
+// targets and the generated resource can refer to the parameters with Go
+// templates, e.g. `config-{{ .name }}`.
+type MatrixEntrySpec struct {
+	// Name of the parameter set.
+	Name string `yaml:"name" json:"name"`
+	// Namespace of the source build.
+	Namespace string `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// NamePrefix of the source build.
+	NamePrefix string `yaml:"namePrefix,omitempty" json:"namePrefix,omitempty"`
+	// NameSuffix of the source build.
+	NameSuffix string `yaml:"nameSuffix,omitempty" json:"nameSuffix,omitempty"`
+	// Images overridden in the source build.
+	Images []ktypes.Image `yaml:"images,omitempty" json:"images,omitempty"`
+}
+
+// matrixEntries returns the parameter sets to render the sources with. Without
+// a matrix, the sources are rendered once as they are, denoted by a nil entry.
+func (r *API) matrixEntries() ([]*MatrixEntrySpec, error) {
+	if len(r.Spec.Matrix) == 0 {
+		return []*MatrixEntrySpec{nil}, nil
+	}
+	names := map[string]bool{}
+	for _, entry := range r.Spec.Matrix {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("matrix entries must specify a name")
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("duplicate matrix entry %q", entry.Name)
+		}
+		names[entry.Name] = true
+	}
+	return r.Spec.Matrix, nil
+}
+
+// wrap adds the name of the entry to the error.
+func (m *MatrixEntrySpec) wrap(err error) error {
+	if m == nil {
+		return err
+	}
+	return fmt.Errorf("matrix %q: %w", m.Name, err)
+}
+
+// expand executes a template with the parameters of the entry.
+func (m *MatrixEntrySpec) expand(text string) (string, error) {
+	if m == nil || !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template %q: %w", text, err)
+	}
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, map[string]string{
+		"name":       m.Name,
+		"namespace":  m.Namespace,
+		"namePrefix": m.NamePrefix,
+		"nameSuffix": m.NameSuffix,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template %q: %w", text, err)
+	}
+	return sb.String(), nil
+}
+
+// expandAll expands the given strings in place.
+func (m *MatrixEntrySpec) expandAll(texts ...*string) error {
+	for _, text := range texts {
+		expanded, err := m.expand(*text)
+		if err != nil {
+			return err
+		}
+		*text = expanded
+	}
+	return nil
+}
+
+// sourceSpec layers the parameters of the entry over the inline kustomization
+// of the source. Raw sources are not built, so they are left as they are.
+func (m *MatrixEntrySpec) sourceSpec(source *SourceSpec) *SourceSpec {
+	if m == nil || source.Raw || source.FromStream != nil {
+		return source
+	}
+	k := ktypes.Kustomization{}
+	if source.Kustomization != nil {
+		k = *source.Kustomization
+	}
+	if m.Namespace != "" {
+		k.Namespace = m.Namespace
+	}
+	if m.NamePrefix != "" {
+		k.NamePrefix = m.NamePrefix
+	}
+	if m.NameSuffix != "" {
+		k.NameSuffix = m.NameSuffix
+	}
+	k.Images = append(append([]ktypes.Image{}, k.Images...), m.Images...)
+
+	layered := *source
+	layered.Kustomization = &k
+	return &layered
+}
+
+// targetSpec expands the templates in the selector and field paths of the target.
+func (m *MatrixEntrySpec) targetSpec(target *TargetSpec) (*TargetSpec, error) {
+	if m == nil {
+		return target, nil
+	}
+	expanded := *target
+	expanded.FieldPaths = append([]string{}, target.FieldPaths...)
+	texts := make([]*string, 0, len(expanded.FieldPaths)+4)
+	for i := range expanded.FieldPaths {
+		texts = append(texts, &expanded.FieldPaths[i])
+	}
+	if target.Select != nil {
+		sel := *target.Select
+		expanded.Select = &sel
+		texts = append(texts, &sel.Name, &sel.Namespace, &sel.LabelSelector, &sel.AnnotationSelector)
+	}
+	if err := m.expandAll(texts...); err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+	return &expanded, nil
+}
+
+// generateSpec expands the templates in the name, namespace and key of the generated resource.
+func (m *MatrixEntrySpec) generateSpec(g *GenerateSpec) (*GenerateSpec, error) {
+	if m == nil {
+		return g, nil
+	}
+	expanded := *g
+	if err := m.expandAll(&expanded.Name, &expanded.Namespace, &expanded.Key); err != nil {
+		return nil, fmt.Errorf("generate: %w", err)
+	}
+	return &expanded, nil
+}
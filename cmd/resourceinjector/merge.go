@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/openapi"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/kustomize/kyaml/yaml/merge2"
+	"sigs.k8s.io/kustomize/kyaml/yaml/walk"
+)
+
+// MergeStrategyType is a typed string for the ways values are combined with existing fields.
+type MergeStrategyType string
+
+// MergeStrategy enumeration for the ways values are combined with existing fields.
+const (
+	// MergeReplace replaces the field with the value (default).
+	MergeReplace MergeStrategyType = "replace"
+	// MergeMerge recursively merges mappings, replacing lists and scalars.
+	MergeMerge MergeStrategyType = "merge"
+	// MergeAppend appends the value to a list.
+	MergeAppend MergeStrategyType = "append"
+	// MergePrepend prepends the value to a list.
+	MergePrepend MergeStrategyType = "prepend"
+	// MergeStrategic applies the value as a strategic merge patch, merging lists
+	// by the merge keys of the OpenAPI schema of the resource.
+	MergeStrategic MergeStrategyType = "strategic"
+)
+
+// mergeStrategy returns the merge strategy of the target options.
+func mergeStrategy(options *TargetOptions) MergeStrategyType {
+	if options == nil || options.Merge == "" {
+		return MergeReplace
+	}
+	return options.Merge
+}
+
+// mergeValue combines the value with the existing content of the target field.
+func mergeValue(t *transform.Target, value *yaml.RNode, strategy MergeStrategyType) error {
+	switch strategy {
+	case MergeMerge:
+		if t.Field.YNode().Kind != yaml.MappingNode || value.YNode().Kind != yaml.MappingNode {
+			t.Field.SetYNode(value.YNode())
+			return nil
+		}
+		return deepMerge(t.Field, value)
+	case MergeAppend, MergePrepend:
+		return mergeList(t, value, strategy == MergePrepend)
+	case MergeStrategic:
+		return strategicMerge(t, value)
+	default:
+		return fmt.Errorf("unrecognized merge strategy: %q", strategy)
+	}
+}
+
+// deepMerge sets the fields of the value on the target, merging mappings
+// present in both recursively.
+func deepMerge(target, value *yaml.RNode) error {
+	return value.VisitFields(func(node *yaml.MapNode) error {
+		key := node.Key.YNode().Value
+		existing := target.Field(key)
+		if existing != nil && existing.Value.YNode().Kind == yaml.MappingNode && node.Value.YNode().Kind == yaml.MappingNode {
+			return deepMerge(existing.Value, node.Value)
+		}
+		return target.PipeE(yaml.SetField(key, node.Value))
+	})
+}
+
+// mergeList adds the elements of a list value, or the value itself, to the
+// target list. Empty fields, like those created for the target, are treated
+// as empty lists.
+func mergeList(t *transform.Target, value *yaml.RNode, prepend bool) error {
+	target := t.Field.YNode()
+	if target.Kind != yaml.SequenceNode {
+		if !yaml.IsYNodeNilOrEmpty(target) {
+			return fmt.Errorf("field %s of %s %q is not a list", strings.Join(t.FieldPath, "."), t.Resource.GetKind(), t.Resource.GetName())
+		}
+		t.Field.SetYNode(&yaml.Node{Kind: yaml.SequenceNode, Tag: yaml.NodeTagSeq})
+		target = t.Field.YNode()
+	}
+	elements := []*yaml.Node{value.YNode()}
+	if value.YNode().Kind == yaml.SequenceNode {
+		elements = value.YNode().Content
+	}
+	if prepend {
+		target.Content = append(append([]*yaml.Node{}, elements...), target.Content...)
+	} else {
+		target.Content = append(target.Content, elements...)
+	}
+	return nil
+}
+
+// strategicMerge applies the value as a strategic merge patch. Lists are
+// merged by the merge keys of the OpenAPI schema of the target resource, and
+// replaced where the schema has none.
+func strategicMerge(t *transform.Target, value *yaml.RNode) error {
+	merged, err := walk.Walker{
+		Sources: []*yaml.RNode{t.Field, value},
+		Visitor: merge2.Merger{},
+		Schema:  fieldSchema(t.Resource, t.FieldPath),
+	}.Walk()
+	if err != nil {
+		return fmt.Errorf("strategic merge into %s of %s %q: %w", strings.Join(t.FieldPath, "."), t.Resource.GetKind(), t.Resource.GetName(), err)
+	}
+	t.Field.SetYNode(merged.YNode())
+	return nil
+}
+
+// fieldSchema looks up the OpenAPI schema of the field of the resource, if any.
+func fieldSchema(res *yaml.RNode, fieldPath []string) *openapi.ResourceSchema {
+	meta, err := res.GetMeta()
+	if err != nil {
+		return nil
+	}
+	schema := openapi.SchemaForResourceType(meta.TypeMeta)
+	for _, p := range fieldPath {
+		if schema == nil {
+			return nil
+		}
+		if yaml.IsListIndex(p) || yaml.IsIdxNumber(p) || (yaml.IsWildcard(p) && isListSchema(schema)) {
+			schema = schema.Elements()
+		} else {
+			schema = schema.Field(p)
+		}
+	}
+	return schema
+}
+
+// isListSchema reports whether the schema describes a list.
+func isListSchema(schema *openapi.ResourceSchema) bool {
+	return schema.Schema != nil && len(schema.Schema.Type) == 1 && schema.Schema.Type[0] == "array"
+}
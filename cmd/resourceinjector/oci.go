@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	// ociRefNameAnnotation names the manifests of an OCI image layout.
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+	// ociTitleAnnotation is the file name of a layer of an OCI artifact.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// ociManifestMediaType is the media type of OCI image manifests.
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// OCISourceSpec is a source read from an OCI image layout directory.
+type OCISourceSpec struct {
+	// Path of the OCI image layout directory, relative to the function config.
+	Path string `yaml:"path" json:"path"`
+	// Reference selects the manifest by its `org.opencontainers.image.ref.name` annotation, e.g. a tag.
+	Reference string `yaml:"reference,omitempty" json:"reference,omitempty"`
+	// Digest selects the manifest by its digest, in the `sha256:<hex>` format.
+	Digest string `yaml:"digest,omitempty" json:"digest,omitempty"`
+	// Dir is the directory within the unpacked layers to render.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// ociDescriptor describes a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociIndex is the index.json of an OCI image layout.
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociSource unpacks the layers of a manifest of the image layout into an
+// in-memory file system. Every blob is verified against its digest.
+func ociSource(spec *OCISourceSpec, baseDir string) (*sourceLocation, error) {
+	if spec.Path == "" {
+		return nil, fmt.Errorf("oci path must be specified")
+	}
+	if spec.Reference == "" && spec.Digest == "" {
+		return nil, fmt.Errorf("oci reference or digest must be specified")
+	}
+	layout := resolvePath(baseDir, spec.Path)
+	if _, err := os.Stat(filepath.Join(layout, "oci-layout")); err != nil {
+		return nil, fmt.Errorf("%q is not an OCI image layout: %w", spec.Path, err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(layout, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI index: %w", err)
+	}
+	index := &ociIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, fmt.Errorf("failed to parse OCI index: %w", err)
+	}
+	var selected []ociDescriptor
+	for _, desc := range index.Manifests {
+		if spec.Reference != "" && desc.Annotations[ociRefNameAnnotation] != spec.Reference {
+			continue
+		}
+		if spec.Digest != "" && desc.Digest != spec.Digest {
+			continue
+		}
+		selected = append(selected, desc)
+	}
+	switch {
+	case len(selected) == 0:
+		return nil, fmt.Errorf("no manifest matching reference %q and digest %q in %q", spec.Reference, spec.Digest, spec.Path)
+	case len(selected) > 1:
+		return nil, fmt.Errorf("several manifests matching reference %q and digest %q in %q", spec.Reference, spec.Digest, spec.Path)
+	case selected[0].MediaType != "" && selected[0].MediaType != ociManifestMediaType:
+		return nil, fmt.Errorf("unsupported manifest media type %q", selected[0].MediaType)
+	}
+
+	content, err = readOCIBlob(layout, selected[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse OCI manifest: %w", err)
+	}
+
+	fSys := filesys.MakeFsInMemory()
+	u := newUnpacker(fSys, memSourceRoot)
+	for _, layer := range manifest.Layers {
+		blob, err := readOCIBlob(layout, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasSuffix(layer.MediaType, "tar+gzip"):
+			err = u.untarGzip(bytes.NewReader(blob))
+		case strings.HasSuffix(layer.MediaType, "tar"):
+			err = u.untar(bytes.NewReader(blob))
+		case layer.Annotations[ociTitleAnnotation] != "":
+			// Artifacts store single files as layers named by their title.
+			err = u.writeFile(layer.Annotations[ociTitleAnnotation], bytes.NewReader(blob))
+		default:
+			err = fmt.Errorf("unsupported layer media type %q without a title", layer.MediaType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack OCI layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	sourcePath, err := subPath(memSourceRoot, spec.Dir)
+	if err != nil {
+		return nil, err
+	}
+	absLayout, err := filepath.Abs(layout)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceLocation{
+		fSys:     fSys,
+		path:     sourcePath,
+		identity: selected[0].Digest,
+		origin:   remoteOrigin(absLayout, selected[0].Digest, spec.Dir),
+		cleanup:  func() {},
+	}, nil
+}
+
+// readOCIBlob reads a blob of the image layout, verifying its digest.
+func readOCIBlob(layout, digest string) ([]byte, error) {
+	algorithm, encoded, ok := strings.Cut(digest, ":")
+	if !ok || strings.ContainsAny(encoded, `/\.`) {
+		return nil, fmt.Errorf("invalid OCI digest %q", digest)
+	}
+	content, err := os.ReadFile(filepath.Join(layout, "blobs", algorithm, encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI blob: %w", err)
+	}
+	if err := verifyDigest(content, digest); err != nil {
+		return nil, fmt.Errorf("OCI blob %s: %w", digest, err)
+	}
+	return content, nil
+}
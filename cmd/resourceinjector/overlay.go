@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// overlayDirName is the virtual directory holding the inline kustomization.
+const overlayDirName = ".resourceinjector-overlay"
+
+// overlayKustomization layers the inline kustomization over the source. It
+// returns a file system containing the kustomization in a virtual directory
+// on top of the source tree, and the path of that directory to build.
+func overlayKustomization(
+	fSys filesys.FileSystem, sourcePath string, k *ktypes.Kustomization, onDisk bool,
+) (filesys.FileSystem, string, error) {
+	absPath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, "", err
+	}
+	if onDisk {
+		// The on-disk file system resolves symlinks, so the overlay has to be placed accordingly.
+		absPath, err = filepath.EvalSymlinks(absPath)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	overlay := *k
+	overlay.Resources = nil
+	mem := filesys.MakeFsInMemory()
+	// Kustomize rejects bases containing the overlay, so it is placed next to the source.
+	overlayDir := filepath.Join(filepath.Dir(absPath), overlayDirName)
+	name := filepath.Base(absPath)
+	if fSys.IsDir(absPath) {
+		// The source directory is the base of the overlay.
+		overlay.Resources = append(overlay.Resources, filepath.Join("..", name))
+	} else {
+		// Files outside of the overlay cannot be loaded as resources, so copy the source file into it.
+		content, err := fSys.ReadFile(absPath)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read source file %q: %w", sourcePath, err)
+		}
+		if err := mem.WriteFile(filepath.Join(overlayDir, name), content); err != nil {
+			return nil, "", err
+		}
+		overlay.Resources = append(overlay.Resources, name)
+	}
+	overlay.Resources = append(overlay.Resources, k.Resources...)
+	if fSys.Exists(overlayDir) {
+		return nil, "", fmt.Errorf("%q already exists", overlayDir)
+	}
+
+	overlay.FixKustomization()
+	content, err := yaml.Marshal(overlay)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := mem.WriteFile(filepath.Join(overlayDir, "kustomization.yaml"), content); err != nil {
+		return nil, "", err
+	}
+	return &overlayFs{upper: mem, lower: fSys}, overlayDir, nil
+}
+
+// overlayFs is a file system layering an upper file system over a lower one.
+// Paths in the upper file system shadow the lower one and all writes go to the
+// upper file system.
+type overlayFs struct {
+	upper filesys.FileSystem
+	lower filesys.FileSystem
+}
+
+var _ filesys.FileSystem = &overlayFs{}
+
+func (o *overlayFs) Create(path string) (filesys.File, error) {
+	return o.upper.Create(path)
+}
+
+func (o *overlayFs) Mkdir(path string) error {
+	return o.upper.Mkdir(path)
+}
+
+func (o *overlayFs) MkdirAll(path string) error {
+	return o.upper.MkdirAll(path)
+}
+
+func (o *overlayFs) RemoveAll(path string) error {
+	return o.upper.RemoveAll(path)
+}
+
+func (o *overlayFs) Open(path string) (filesys.File, error) {
+	return o.layer(path).Open(path)
+}
+
+func (o *overlayFs) IsDir(path string) bool {
+	return o.upper.IsDir(path) || o.lower.IsDir(path)
+}
+
+func (o *overlayFs) ReadDir(path string) ([]string, error) {
+	var names []string
+	for _, fSys := range []filesys.FileSystem{o.upper, o.lower} {
+		if !fSys.IsDir(path) {
+			continue
+		}
+		entries, err := fSys.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, entries...)
+	}
+	if names == nil && !o.IsDir(path) {
+		return o.lower.ReadDir(path)
+	}
+	return dedupSorted(names), nil
+}
+
+func (o *overlayFs) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	return o.layer(path).CleanedAbs(path)
+}
+
+func (o *overlayFs) Exists(path string) bool {
+	return o.upper.Exists(path) || o.lower.Exists(path)
+}
+
+func (o *overlayFs) Glob(pattern string) ([]string, error) {
+	upper, err := o.upper.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	lower, err := o.lower.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return dedupSorted(append(upper, lower...)), nil
+}
+
+func (o *overlayFs) ReadFile(path string) ([]byte, error) {
+	return o.layer(path).ReadFile(path)
+}
+
+func (o *overlayFs) WriteFile(path string, data []byte) error {
+	return o.upper.WriteFile(path, data)
+}
+
+func (o *overlayFs) Walk(path string, walkFn filepath.WalkFunc) error {
+	seen := map[string]bool{}
+	if o.lower.Exists(path) {
+		err := o.lower.Walk(path, func(p string, info fs.FileInfo, err error) error {
+			seen[p] = true
+			return walkFn(p, info, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !o.upper.Exists(path) {
+		return nil
+	}
+	return o.upper.Walk(path, func(p string, info fs.FileInfo, err error) error {
+		if seen[p] {
+			return nil
+		}
+		return walkFn(p, info, err)
+	})
+}
+
+// layer returns the file system a path is read from.
+func (o *overlayFs) layer(path string) filesys.FileSystem {
+	if o.upper.Exists(path) {
+		return o.upper
+	}
+	return o.lower
+}
+
+func dedupSorted(names []string) []string {
+	sort.Strings(names)
+	result := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			result = append(result, name)
+		}
+	}
+	return result
+}
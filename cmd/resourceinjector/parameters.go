@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/kio/filters"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// parametersConfigMapName is the name of the local ConfigMap holding the
+// parameters of the source build, which replacements read them from.
+const parametersConfigMapName = "resourceinjector-parameters"
+
+// ParameterSpec pushes a value of each target resource into the source build.
+type ParameterSpec struct {
+	// Name of the parameter.
+	Name string `yaml:"name" json:"name"`
+	// FieldPath of the value in the target resource.
+	FieldPath string `yaml:"fieldPath" json:"fieldPath"`
+	// Namespace sets the namespace of the source build to the value.
+	Namespace bool `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// NamePrefix sets the name prefix of the source build to the value.
+	NamePrefix bool `yaml:"namePrefix,omitempty" json:"namePrefix,omitempty"`
+	// Targets of the source build the value is written into, as in kustomize replacements.
+	Targets []*ktypes.TargetSelector `yaml:"targets,omitempty" json:"targets,omitempty"`
+}
+
+// parameterizedSource renders a source once per distinct set of parameters.
+type parameterizedSource struct {
+	source  *SourceSpec
+	baseDir string
+	// keys are the rendered parameter sets in the order they were first rendered.
+	keys  []string
+	cache map[string]*injection
+}
+
+// newParameterizedSource validates the parameters of the source.
+func newParameterizedSource(source *SourceSpec, baseDir string) (*parameterizedSource, error) {
+	if source.Raw {
+		return nil, fmt.Errorf("parameters cannot be used with raw sources")
+	}
+	if source.FromStream != nil {
+		return nil, fmt.Errorf("parameters cannot be used with fromStream sources")
+	}
+	names := map[string]bool{}
+	for _, param := range source.Parameters {
+		switch {
+		case param.Name == "":
+			return nil, fmt.Errorf("parameters must specify a name")
+		case names[param.Name]:
+			return nil, fmt.Errorf("duplicate parameter %q", param.Name)
+		case param.FieldPath == "":
+			return nil, fmt.Errorf("parameter %q must specify a fieldPath", param.Name)
+		case !param.Namespace && !param.NamePrefix && len(param.Targets) == 0:
+			return nil, fmt.Errorf("parameter %q must specify namespace, namePrefix or targets", param.Name)
+		}
+		names[param.Name] = true
+	}
+	return &parameterizedSource{source: source, baseDir: baseDir, cache: map[string]*injection{}}, nil
+}
+
+// render renders the source with the parameters of the target resource,
+// reusing the result for targets with the same parameters.
+func (p *parameterizedSource) render(resource *yaml.RNode) (*injection, error) {
+	values := make([]string, len(p.source.Parameters))
+	for i, param := range p.source.Parameters {
+		node, err := resource.Pipe(yaml.Lookup(kyaml_utils.SmarterPathSplitter(param.FieldPath, ".")...))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", param.Name, err)
+		}
+		if node.IsNilOrEmpty() || node.YNode().Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("parameter %q: fieldPath %q of %s %q must be a scalar",
+				param.Name, param.FieldPath, resource.GetKind(), resource.GetName())
+		}
+		values[i] = node.YNode().Value
+	}
+
+	key := strings.Join(values, "\x00")
+	if inj, ok := p.cache[key]; ok {
+		return inj, nil
+	}
+	source := *p.source
+	source.Parameters = nil
+	source.Kustomization = parameterKustomization(p.source.Kustomization, p.source.Parameters, values)
+	inj, err := renderSource(&source, p.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render source with parameters %s: %w", strings.Join(values, ", "), err)
+	}
+	p.keys = append(p.keys, key)
+	p.cache[key] = inj
+	return inj, nil
+}
+
+// values returns the rendered values in the order they were first rendered.
+func (p *parameterizedSource) values() []*yaml.RNode {
+	values := make([]*yaml.RNode, 0, len(p.keys))
+	for _, key := range p.keys {
+		values = append(values, p.cache[key].value)
+	}
+	return values
+}
+
+// parameterKustomization layers the parameters over the inline kustomization.
+// The values are held by a local ConfigMap, which the replacements read them
+// from and kustomize drops from the output.
+func parameterKustomization(k *ktypes.Kustomization, params []*ParameterSpec, values []string) *ktypes.Kustomization {
+	overlay := ktypes.Kustomization{}
+	if k != nil {
+		overlay = *k
+	}
+	overlay.ConfigMapGenerator = append([]ktypes.ConfigMapArgs{}, overlay.ConfigMapGenerator...)
+	overlay.Replacements = append([]ktypes.ReplacementField{}, overlay.Replacements...)
+
+	args := ktypes.ConfigMapArgs{GeneratorArgs: ktypes.GeneratorArgs{
+		Name: parametersConfigMapName,
+		Options: &ktypes.GeneratorOptions{
+			DisableNameSuffixHash: true,
+			Annotations:           map[string]string{filters.LocalConfigAnnotation: "true"},
+		},
+	}}
+	for i, param := range params {
+		if param.Namespace {
+			overlay.Namespace = values[i]
+		}
+		if param.NamePrefix {
+			overlay.NamePrefix = values[i]
+		}
+		if len(param.Targets) == 0 {
+			continue
+		}
+		args.LiteralSources = append(args.LiteralSources, param.Name+"="+values[i])
+		overlay.Replacements = append(overlay.Replacements, ktypes.ReplacementField{
+			Replacement: ktypes.Replacement{
+				Source: &ktypes.SourceSelector{
+					ResId:     resid.NewResIdKindOnly("ConfigMap", parametersConfigMapName),
+					FieldPath: "data." + param.Name,
+				},
+				Targets: param.Targets,
+			},
+		})
+	}
+	if len(args.LiteralSources) > 0 {
+		overlay.ConfigMapGenerator = append(overlay.ConfigMapGenerator, args)
+	}
+	return &overlay
+}
+
+// parameterizedValue injects the source rendered with the parameters of each target resource.
+type parameterizedValue struct {
+	source *parameterizedSource
+	// options of the target, if any.
+	options *TargetOptions
+}
+
+func (v *parameterizedValue) CreateKind() yaml.Kind {
+	if v.source.source.Mode == SourceModeStructured {
+		return yaml.MappingNode
+	}
+	return yaml.ScalarNode
+}
+
+func (v *parameterizedValue) Apply(t *transform.Target) error {
+	inj, err := v.source.render(t.Resource)
+	if err != nil {
+		return err
+	}
+	return (&setValue{Value: inj.value, Base64: inj.base64, Options: v.options, Commits: inj.commits()}).Apply(t)
+}
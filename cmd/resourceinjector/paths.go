@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+)
+
+// baseDir returns the directory relative source paths are resolved against.
+//
+// It is the directory of the function config file, as recorded by the path
+// annotations or passed in standalone mode. Kustomize sets neither, but runs
+// the function from the root of the kustomization including it, so the
+// working directory is used instead.
+func (r *API) baseDir() string {
+	annotations := r.Metadata.Annotations
+	path, found := annotations[kioutil.PathAnnotation]
+	if !found {
+		path, found = annotations[kioutil.LegacyPathAnnotation]
+	}
+	if !found {
+		path = r.configFile
+	}
+	if path == "" {
+		return "."
+	}
+	return filepath.Dir(path)
+}
+
+// resolvePath resolves a path relative to baseDir, leaving absolute paths untouched.
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// loadRestrictionsEnv enforces load restrictions on every source, regardless of
+// the function config. It allows platform teams to make `rootOnly` mandatory.
+const loadRestrictionsEnv = "RESOURCEINJECTOR_LOAD_RESTRICTIONS"
+
+// sourceLoadRestrictions returns the load restrictions applied to the source path.
+// Restrictions enforced through the environment take precedence over the function config.
+func sourceLoadRestrictions(opts *SourceOptions) (LoadRestrictionsType, error) {
+	if env := LoadRestrictionsType(os.Getenv(loadRestrictionsEnv)); env != "" {
+		if _, err := parseLoadRestrictions(env); err != nil {
+			return "", fmt.Errorf("invalid %s: %w", loadRestrictionsEnv, err)
+		}
+		if env == LoadRestrictionsRootOnly {
+			return env, nil
+		}
+	}
+	if opts == nil {
+		return "", nil
+	}
+	return opts.LoadRestrictions, nil
+}
+
+// checkRootOnly verifies that the path, with all symlinks resolved, is within
+// the kustomization root, i.e. baseDir.
+func checkRootOnly(baseDir, path string) error {
+	root, err := filepath.Abs(baseDir)
+	if err != nil {
+		return err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if !isWithin(root, absPath) {
+		return fmt.Errorf("source path %q is outside of the kustomization root %q, which is not allowed with load restrictions %q",
+			path, root, LoadRestrictionsRootOnly)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve source path %q: %w", path, err)
+	}
+	if !isWithin(realRoot, realPath) {
+		return fmt.Errorf("source path %q resolves to %q through a symlink, outside of the kustomization root %q, which is not allowed with load restrictions %q",
+			path, realPath, realRoot, LoadRestrictionsRootOnly)
+	}
+	return nil
+}
+
+// isWithin reports whether the absolute path is the root or below it.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// isGlob reports whether the path of a raw source is a glob pattern. Paths of
+// other sources are always taken literally.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// rawSource reads the source files verbatim. A single file is injected as a
+// string, while globs and directories map each file name to its content, like
+// a configMapGenerator.
+func rawSource(source *SourceSpec, baseDir string) (*injection, error) {
+	switch {
+	case source.Select != nil, len(source.fieldPaths()) > 0, source.Join != "", source.Expression != "", source.Kustomization != nil:
+		return nil, fmt.Errorf("select, fieldPath(s), join, expression and kustomization cannot be used with raw sources")
+	case source.Mode == SourceModeStructured:
+		return nil, fmt.Errorf("structured mode cannot be used with raw sources")
+	case source.isRemote():
+		return nil, fmt.Errorf("git, archive, oci and url cannot be used with raw sources")
+	case source.FromStream != nil:
+		return nil, fmt.Errorf("fromStream cannot be used with raw sources")
+	case source.Format != nil && (source.Format.Indent != 0 || source.Format.SortKeys):
+		return nil, fmt.Errorf("format indent and sortKeys cannot be used with raw sources")
+	}
+
+	sourcePath := resolvePath(baseDir, source.Path)
+	files, single, err := rawSourceFiles(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	restrictions, err := sourceLoadRestrictions(source.Options)
+	if err != nil {
+		return nil, err
+	}
+	if restrictions == LoadRestrictionsRootOnly {
+		for _, file := range files {
+			if err := checkRootOnly(baseDir, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	base64 := source.Encoding == SourceEncodingBase64 || source.Encoding == SourceEncodingGzipBase64
+	if single {
+		content, err := readRawFile(files[0], source)
+		if err != nil {
+			return nil, err
+		}
+		return &injection{value: source.Format.scalar(content), base64: base64}, nil
+	}
+
+	value := yaml.NewMapRNode(nil)
+	for _, file := range files {
+		key := filepath.Base(file)
+		if value.Field(key) != nil {
+			return nil, fmt.Errorf("multiple source files named %q", key)
+		}
+		content, err := readRawFile(file, source)
+		if err != nil {
+			return nil, err
+		}
+		if err := value.PipeE(yaml.SetField(key, source.Format.scalar(content))); err != nil {
+			return nil, err
+		}
+	}
+	return &injection{value: value, base64: base64, files: true}, nil
+}
+
+// rawSourceFiles returns the files matched by the source path in lexical order,
+// and whether the path refers to a single file.
+func rawSourceFiles(sourcePath string) ([]string, bool, error) {
+	var candidates []string
+	if isGlob(sourcePath) {
+		matches, err := filepath.Glob(sourcePath)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid source glob %q: %w", sourcePath, err)
+		}
+		candidates = matches
+	} else {
+		info, err := os.Stat(sourcePath)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read source %q: %w", sourcePath, err)
+		}
+		if !info.IsDir() {
+			return []string{sourcePath}, true, nil
+		}
+		entries, err := os.ReadDir(sourcePath)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read source directory %q: %w", sourcePath, err)
+		}
+		for _, entry := range entries {
+			candidates = append(candidates, filepath.Join(sourcePath, entry.Name()))
+		}
+	}
+
+	// Only regular files are injected; subdirectories are skipped.
+	var files []string
+	for _, candidate := range candidates {
+		info, err := os.Stat(candidate)
+		if err != nil {
+			return nil, false, err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, candidate)
+		}
+	}
+	if len(files) == 0 {
+		return nil, false, fmt.Errorf("no files found for source %q", sourcePath)
+	}
+	sort.Strings(files)
+	return files, false, nil
+}
+
+func readRawFile(file string, source *SourceSpec) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source file %q: %w", file, err)
+	}
+	content, err = source.Substitute.apply(content)
+	if err != nil {
+		return "", fmt.Errorf("source file %q: %w", file, err)
+	}
+	encoded, err := encodeBytes([]byte(source.Format.trimNewlines(string(content))), source.Encoding)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode source file %q: %w", file, err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sourceChainEnv passes the chain of sources being rendered to nested injectors,
+	// one per line, as remote sources contain colons. The first entry is the root
+	// of the outermost kustomization.
+	sourceChainEnv = "RESOURCEINJECTOR_SOURCE_CHAIN"
+	// maxDepthEnv passes the most restrictive depth limit to nested injectors.
+	maxDepthEnv = "RESOURCEINJECTOR_MAX_DEPTH"
+	// defaultMaxDepth is the depth limit when none is configured.
+	defaultMaxDepth = 10
+)
+
+// sourceChain is the chain of sources rendered by nested injectors. Local
+// sources are absolute paths, remote ones are identified by their origin.
+type sourceChain struct {
+	root     string
+	sources  []string
+	maxDepth int
+}
+
+// currentSourceChain returns the chain of sources this injector is nested in.
+func currentSourceChain() (*sourceChain, error) {
+	chain := &sourceChain{maxDepth: defaultMaxDepth}
+	if env := os.Getenv(maxDepthEnv); env != "" {
+		maxDepth, err := strconv.Atoi(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", maxDepthEnv, err)
+		}
+		chain.maxDepth = maxDepth
+	}
+	if env := os.Getenv(sourceChainEnv); env != "" {
+		entries := strings.Split(env, "\n")
+		chain.root, chain.sources = entries[0], entries[1:]
+		return chain, nil
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	chain.root = root
+	return chain, nil
+}
+
+// enter returns the chain extended with the source entry, failing on cycles or
+// when the depth limit is exceeded. The limit is the lower of maxDepth, if set,
+// and the limit inherited from outer injectors.
+func (c *sourceChain) enter(entry string, maxDepth int) (*sourceChain, error) {
+	next := &sourceChain{
+		root:     c.root,
+		sources:  append(append([]string{}, c.sources...), entry),
+		maxDepth: c.maxDepth,
+	}
+	if maxDepth > 0 && maxDepth < next.maxDepth {
+		next.maxDepth = maxDepth
+	}
+
+	for _, s := range c.sources {
+		if s == entry {
+			return nil, fmt.Errorf("source cycle detected: %s", next)
+		}
+	}
+	if len(next.sources) > next.maxDepth {
+		return nil, fmt.Errorf("maximum source depth %d exceeded: %s", next.maxDepth, next)
+	}
+	return next, nil
+}
+
+// setenv exports the chain to nested injectors, returning a function restoring the environment.
+func (c *sourceChain) setenv() (func(), error) {
+	prevChain, hadChain := os.LookupEnv(sourceChainEnv)
+	prevDepth, hadDepth := os.LookupEnv(maxDepthEnv)
+	restore := func() {
+		restoreEnv(sourceChainEnv, prevChain, hadChain)
+		restoreEnv(maxDepthEnv, prevDepth, hadDepth)
+	}
+
+	entries := append([]string{c.root}, c.sources...)
+	if err := os.Setenv(sourceChainEnv, strings.Join(entries, "\n")); err != nil {
+		restore()
+		return nil, err
+	}
+	if err := os.Setenv(maxDepthEnv, strconv.Itoa(c.maxDepth)); err != nil {
+		restore()
+		return nil, err
+	}
+	return restore, nil
+}
+
+func restoreEnv(key, value string, ok bool) {
+	if ok {
+		_ = os.Setenv(key, value)
+	} else {
+		_ = os.Unsetenv(key)
+	}
+}
+
+// String renders the chain as a trace of sources relative to the outermost root.
+func (c *sourceChain) String() string {
+	trace := make([]string, 0, len(c.sources))
+	for _, s := range c.sources {
+		if rel, err := filepath.Rel(c.root, s); err == nil {
+			s = rel
+		}
+		trace = append(trace, s)
+	}
+	return strings.Join(trace, " -> ")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// SecretEncodingType is a typed string for Secret value encodings.
+type SecretEncodingType string
+
+// SecretEncoding enumeration for the ways values are written into Secrets.
+const (
+	// SecretEncodingAuto base64 encodes values written under `data` and writes
+	// anything else as is (default).
+	SecretEncodingAuto SecretEncodingType = "auto"
+	// SecretEncodingRaw writes values as is.
+	SecretEncodingRaw SecretEncodingType = "raw"
+	// SecretEncodingBase64 base64 encodes values.
+	SecretEncodingBase64 SecretEncodingType = "base64"
+)
+
+// isSecret reports whether the resource is a core v1 Secret.
+func isSecret(resource *yaml.RNode) bool {
+	return resource.GetKind() == "Secret" && resource.GetApiVersion() == "v1"
+}
+
+// encodeSecretValue base64 encodes a scalar value, or the scalar values of a
+// mapping, in place when it is written into a Secret field that requires it.
+// Values that are already base64 encoded are left untouched in auto mode.
+func encodeSecretValue(t *transform.Target, value *yaml.RNode, encoded bool, options *TargetOptions) error {
+	if !isSecret(t.Resource) {
+		return nil
+	}
+	var scalars []*yaml.Node
+	switch node := value.YNode(); node.Kind {
+	case yaml.ScalarNode:
+		scalars = []*yaml.Node{node}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if node.Content[i].Kind == yaml.ScalarNode {
+				scalars = append(scalars, node.Content[i])
+			}
+		}
+	default:
+		return nil
+	}
+
+	encoding := SecretEncodingAuto
+	if options != nil && options.SecretEncoding != "" {
+		encoding = options.SecretEncoding
+	}
+
+	switch encoding {
+	case SecretEncodingAuto:
+		if encoded || len(t.FieldPath) == 0 || t.FieldPath[0] != "data" {
+			return nil
+		}
+	case SecretEncodingRaw:
+		return nil
+	case SecretEncodingBase64:
+	default:
+		return fmt.Errorf("unrecognized secret encoding: %q", encoding)
+	}
+
+	for _, scalar := range scalars {
+		scalar.Value = base64.StdEncoding.EncodeToString([]byte(scalar.Value))
+		scalar.Tag = yaml.NodeTagString
+		scalar.Style = 0
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ShardSpec splits a multi-document source into shards that fit the size
+// limits, along with an index listing them.
+type ShardSpec struct {
+	// MaxSize of a shard in bytes. Defaults to the size limit of the target field,
+	// which the shards injected into a target must fit in total.
+	MaxSize int `yaml:"maxSize,omitempty" json:"maxSize,omitempty"`
+	// IndexKey is the key the index is stored under. Defaults to the key of the target field.
+	IndexKey string `yaml:"indexKey,omitempty" json:"indexKey,omitempty"`
+}
+
+// shard splits the documents of the source into as few consecutive shards as
+// possible, each within maxSize bytes once encoded. Each document is encoded
+// once, and the shards are packed by adding up the sizes of their documents.
+func (inj *injection) shard(maxSize int) ([]string, error) {
+	if len(inj.documents) == 0 {
+		return nil, fmt.Errorf("only string sources rendered from documents can be sharded")
+	}
+	sizes := make([]int, len(inj.documents))
+	// trims is the change in size when the document ends a shard, from the
+	// trailing newline policy of the format.
+	trims := make([]int, len(inj.documents))
+	for i, doc := range inj.documents {
+		content, err := inj.encodeDocument(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode source: %w", err)
+		}
+		sizes[i] = len(content)
+		trims[i] = len(inj.format.trimNewlines(content)) - len(content)
+	}
+	separator := len(inj.documentSeparator())
+
+	var shards []string
+	start, size := 0, 0
+	for i := range inj.documents {
+		next := sizes[i]
+		if i > start {
+			next += size + separator
+		}
+		if i > start && inj.encodedSize(next+trims[i]) > maxSize {
+			content, err := inj.encodeShard(start, i, maxSize)
+			if err != nil {
+				return nil, err
+			}
+			shards = append(shards, content)
+			start, next = i, sizes[i]
+		}
+		size = next
+	}
+	content, err := inj.encodeShard(start, len(inj.documents), maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return append(shards, content), nil
+}
+
+// encodeShard encodes the documents of a shard, checking that it fits.
+func (inj *injection) encodeShard(start, end, maxSize int) (string, error) {
+	content, err := encodeSource(inj.documents[start:end], inj.encoding, inj.format)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode source: %w", err)
+	}
+	if len(content) <= maxSize {
+		return content, nil
+	}
+	if end-start == 1 {
+		return "", fmt.Errorf("document %d of the source is %d bytes, exceeding the shard size of %d bytes",
+			start, len(content), maxSize)
+	}
+	return "", fmt.Errorf("documents %d to %d of the source are %d bytes, exceeding the shard size of %d bytes",
+		start, end-1, len(content), maxSize)
+}
+
+// encodeDocument encodes a single document as it appears in a shard, before
+// the shard is base64 encoded.
+func (inj *injection) encodeDocument(doc *yaml.RNode) (string, error) {
+	switch inj.encoding {
+	case SourceEncodingYAML, SourceEncodingBase64, SourceEncodingGzipBase64, "":
+		return yamlString(doc, inj.format.indent())
+	default:
+		return encodeDocuments([]*yaml.RNode{doc}, inj.encoding, inj.format.indent())
+	}
+}
+
+// documentSeparator returns the separator between the encoded documents of a shard.
+func (inj *injection) documentSeparator() string {
+	switch inj.encoding {
+	case SourceEncodingYAML, SourceEncodingBase64, SourceEncodingGzipBase64, "":
+		return documentSeparator
+	default:
+		return ""
+	}
+}
+
+// encodedSize returns the size of a shard from the size of its content before
+// it is base64 encoded. Shards compressed with gzip are packed by the size of
+// their uncompressed content.
+func (inj *injection) encodedSize(size int) int {
+	if inj.base64 {
+		return base64.StdEncoding.EncodedLen(size)
+	}
+	return size
+}
+
+// shardsSize returns the total size of the shards as counted against the size
+// limits, i.e. decoded when they are base64 encoded.
+func (inj *injection) shardsSize(shards []string) int {
+	size := 0
+	for _, content := range shards {
+		if inj.base64 {
+			size += base64.StdEncoding.DecodedLen(len(content))
+		} else {
+			size += len(content)
+		}
+	}
+	return size
+}
+
+// shardKey returns the key of the i-th shard of the given key, e.g. `app-0.yaml` for `app.yaml`.
+func shardKey(key string, i int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, ext), i, ext)
+}
+
+// shardIndex lists the names of the shards as a YAML sequence.
+func shardIndex(names []string) (*yaml.RNode, error) {
+	index := yaml.NewListRNode(names...)
+	content, err := index.String()
+	if err != nil {
+		return nil, err
+	}
+	return yaml.NewScalarRNode(content), nil
+}
+
+// joinFieldPath joins a split field path, bracketing the elements containing dots.
+func joinFieldPath(fieldPath []string) string {
+	elems := make([]string, len(fieldPath))
+	for i, elem := range fieldPath {
+		if strings.Contains(elem, ".") && !strings.HasPrefix(elem, "[") {
+			elem = "[" + elem + "]"
+		}
+		elems[i] = elem
+	}
+	return strings.Join(elems, ".")
+}
+
+// applyShards injects the shards of the source into keys next to each target
+// field, and their index into the index key.
+func (t *TargetSpec) applyShards(items []*yaml.RNode, rs *renderedSources) ([]*yaml.RNode, error) {
+	if len(t.Sources) > 0 {
+		return nil, fmt.Errorf("only a single source can be sharded")
+	}
+	if len(t.FieldPaths) == 0 {
+		return nil, fmt.Errorf("fieldPaths must be specified when sharding")
+	}
+	inj, err := rs.get(t.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fp := range t.FieldPaths {
+		fieldPath := kyaml_utils.SmarterPathSplitter(fp, ".")
+		parent, key := fieldPath[:len(fieldPath)-1:len(fieldPath)-1], fieldPath[len(fieldPath)-1]
+		if len(parent) == 0 || strings.ContainsAny(key, "*[") {
+			return nil, fmt.Errorf("sharded field path %q must end in a key of a mapping", fp)
+		}
+		limit := sizeLimit(fieldPath)
+		maxSize := t.Shard.MaxSize
+		if maxSize == 0 {
+			maxSize = limit
+		}
+		shards, err := inj.shard(maxSize)
+		if err != nil {
+			return nil, err
+		}
+		// All shards land in the same resource, so together they must fit its size limit.
+		if size := inj.shardsSize(shards); size > limit {
+			return nil, fmt.Errorf("shards of the source are %d bytes in total, exceeding the limit of %d bytes "+
+				"of the target resource: use spec.generate.shard to split it across resources", size, limit)
+		}
+
+		keys := make([]string, len(shards))
+		for i, content := range shards {
+			keys[i] = shardKey(key, i)
+			setter := &setValue{Value: inj.format.scalar(content), Base64: inj.base64, Options: t.Options, Commits: inj.commits()}
+			items, err = transform.Apply(setter, items, t.shardSelectors(append(parent, keys[i])))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		index, err := shardIndex(keys)
+		if err != nil {
+			return nil, err
+		}
+		indexKey := key
+		if t.Shard.IndexKey != "" {
+			indexKey = t.Shard.IndexKey
+		}
+		setter := &setValue{Value: index, Options: t.Options}
+		items, err = transform.Apply(setter, items, t.shardSelectors(append(parent, indexKey)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// shardSelectors selects the given field of the targets, creating it if missing.
+func (t *TargetSpec) shardSelectors(fieldPath []string) []*transform.TargetSelector {
+	selector := t.selector([]string{joinFieldPath(fieldPath)})
+	if selector.Options == nil {
+		selector.Options = &transform.FieldOptions{}
+	}
+	selector.Options.Create = true
+	return []*transform.TargetSelector{selector}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Size limits enforced by the Kubernetes API server.
+const (
+	// maxDataSize is the limit of the data of a ConfigMap or Secret.
+	maxDataSize = 1024 * 1024
+	// maxAnnotationsSize is the limit of all annotations of a resource.
+	maxAnnotationsSize = 256 * 1024
+)
+
+// isAnnotationPath reports whether the field path points into the annotations.
+func isAnnotationPath(fieldPath []string) bool {
+	return len(fieldPath) > 1 && fieldPath[0] == yaml.MetadataField && fieldPath[1] == yaml.AnnotationsField
+}
+
+// sizeLimit returns the size limit of the field at the given path.
+func sizeLimit(fieldPath []string) int {
+	if isAnnotationPath(fieldPath) {
+		return maxAnnotationsSize
+	}
+	return maxDataSize
+}
+
+// validateSize checks that the target resource stays within the size limits
+// of the API server after injecting into the target field, rather than failing
+// much later when it is applied.
+func validateSize(t *transform.Target) error {
+	if isAnnotationPath(t.FieldPath) {
+		size := 0
+		for k, v := range t.Resource.GetAnnotations() {
+			if !isInternalAnnotation(k) {
+				size += len(k) + len(v)
+			}
+		}
+		if size > maxAnnotationsSize {
+			return fmt.Errorf("annotations of %s %q are %d bytes, exceeding the limit of %d bytes",
+				t.Resource.GetKind(), t.Resource.GetName(), size, maxAnnotationsSize)
+		}
+		return nil
+	}
+
+	var size int
+	switch {
+	case t.Resource.GetKind() == "ConfigMap" && t.Resource.GetApiVersion() == "v1":
+		size = fieldsSize(t.Resource, "data", false) + fieldsSize(t.Resource, "binaryData", true)
+	case isSecret(t.Resource):
+		size = fieldsSize(t.Resource, "data", true) + fieldsSize(t.Resource, "stringData", false)
+	default:
+		return nil
+	}
+	if size > maxDataSize {
+		return fmt.Errorf("data of %s %q is %d bytes, exceeding the limit of %d bytes: consider sharding the source",
+			t.Resource.GetKind(), t.Resource.GetName(), size, maxDataSize)
+	}
+	return nil
+}
+
+// internalAnnotationPrefixes are the prefixes of the annotations kustomize uses
+// to track resources, which are removed from the output.
+var internalAnnotationPrefixes = []string{
+	"internal.config.kubernetes.io/",
+	"config.kubernetes.io/",
+	"config.k8s.io/",
+	"kustomize.config.k8s.io/",
+}
+
+// isInternalAnnotation reports whether the annotation is not part of the output.
+func isInternalAnnotation(key string) bool {
+	for _, prefix := range internalAnnotationPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldsSize sums the size of the values of a mapping field, decoding them
+// first when they are base64 encoded.
+func fieldsSize(resource *yaml.RNode, field string, encoded bool) int {
+	m := resource.Field(field)
+	if m == nil || m.Value.YNode().Kind != yaml.MappingNode {
+		return 0
+	}
+	size := 0
+	content := m.Value.YNode().Content
+	for i := 1; i < len(content); i += 2 {
+		value := content[i].Value
+		if encoded {
+			if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+				size += len(decoded)
+				continue
+			}
+		}
+		size += len(value)
+	}
+	return size
+}
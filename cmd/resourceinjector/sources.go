@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// NamedSourceSpec is a source the targets can refer to by name.
+type NamedSourceSpec struct {
+	Name       string `yaml:"name" json:"name"`
+	SourceSpec `yaml:",inline" json:",inline"`
+}
+
+// TargetSpec selects the fields the content of one or more sources is injected into.
+type TargetSpec struct {
+	Select     *ktypes.Selector `yaml:"select" json:"select"`
+	FieldPaths []string         `yaml:"fieldPaths" json:"fieldPaths"`
+	Options    *TargetOptions   `yaml:"options,omitempty" json:"options,omitempty"`
+
+	SourceRef `yaml:",inline" json:",inline"`
+	// Shard splits the source into several keys next to the target fields.
+	Shard *ShardSpec `yaml:"shard,omitempty" json:"shard,omitempty"`
+}
+
+// TargetOptions extends the field options with how the content is injected.
+type TargetOptions struct {
+	transform.FieldOptions `yaml:",inline" json:",inline"`
+	// SecretEncoding controls how values are encoded when written into Secrets.
+	SecretEncoding SecretEncodingType `yaml:"secretEncoding,omitempty" json:"secretEncoding,omitempty"`
+	// Merge controls how values are combined with the existing content of the field.
+	Merge MergeStrategyType `yaml:"merge,omitempty" json:"merge,omitempty"`
+}
+
+// selector selects the target fields, with the given field paths.
+func (t *TargetSpec) selector(fieldPaths []string) *transform.TargetSelector {
+	selector := &transform.TargetSelector{Select: t.Select, FieldPaths: fieldPaths}
+	if t.Options != nil {
+		options := t.Options.FieldOptions
+		selector.Options = &options
+	}
+	return selector
+}
+
+// SourceRef refers to the sources whose content is injected.
+type SourceRef struct {
+	// Source is the name of the source to inject. Defaults to `spec.source`.
+	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Sources are the names of several sources whose content is concatenated.
+	Sources []string `yaml:"sources,omitempty" json:"sources,omitempty"`
+	// Separator between the content of several sources. Defaults to a YAML document separator.
+	Separator *string `yaml:"separator,omitempty" json:"separator,omitempty"`
+}
+
+// injection is the rendered content of a source, ready to be injected.
+type injection struct {
+	value *yaml.RNode
+	// base64 is set when the value is already base64 encoded.
+	base64 bool
+	// files is set when the value maps file names to their content.
+	files bool
+	// commit is the resolved commit of git sources.
+	commit string
+	// documents are the rendered documents of string sources, which can be sharded.
+	documents []*yaml.RNode
+	// encoding of the documents.
+	encoding SourceEncodingType
+	// format of the documents.
+	format *FormatSpec
+}
+
+// renderedSources holds the rendered content of every source by name.
+// The default source is stored under the empty name.
+type renderedSources struct {
+	names      []string
+	injections map[string]*injection
+	// parameterized sources are rendered per target resource.
+	parameterized map[string]*parameterizedSource
+	// removed are the resources of the stream rendered as sources and dropped from the output.
+	removed []*yaml.RNode
+}
+
+// values returns the rendered values in the order the sources are declared.
+func (rs *renderedSources) values() []*yaml.RNode {
+	values := make([]*yaml.RNode, 0, len(rs.names))
+	for _, name := range rs.names {
+		if p, ok := rs.parameterized[name]; ok {
+			values = append(values, p.values()...)
+			continue
+		}
+		values = append(values, rs.injections[name].value)
+	}
+	return values
+}
+
+// add renders a source, or prepares rendering it per target resource when it has parameters.
+func (rs *renderedSources) add(name string, source *SourceSpec, baseDir string) error {
+	if len(source.Parameters) > 0 {
+		p, err := newParameterizedSource(source, baseDir)
+		if err != nil {
+			return err
+		}
+		rs.parameterized[name] = p
+	} else {
+		inj, err := renderSource(source, baseDir)
+		if err != nil {
+			return err
+		}
+		rs.injections[name] = inj
+	}
+	if source.FromStream != nil && source.FromStream.Remove {
+		rs.removed = append(rs.removed, source.streamed...)
+	}
+	rs.names = append(rs.names, name)
+	return nil
+}
+
+// get returns the rendered source with the given name.
+func (rs *renderedSources) get(name string) (*injection, error) {
+	if _, ok := rs.parameterized[name]; ok {
+		if name == "" {
+			return nil, fmt.Errorf("the default source has parameters and can only be injected into targets on its own")
+		}
+		return nil, fmt.Errorf("source %q has parameters and can only be injected into targets on its own", name)
+	}
+	inj, ok := rs.injections[name]
+	if !ok {
+		if name == "" {
+			return nil, fmt.Errorf("a source must be referred to, as there is no default source")
+		}
+		return nil, fmt.Errorf("unknown source %q", name)
+	}
+	return inj, nil
+}
+
+// renderSources renders the default source and every named source once, with
+// the parameters of the matrix entry, if any.
+func (r *API) renderSources(entry *MatrixEntrySpec, items []*yaml.RNode) (*renderedSources, error) {
+	if r.Spec.Source == nil && len(r.Spec.Sources) == 0 {
+		return nil, fmt.Errorf("source or sources must be specified")
+	}
+
+	rs := &renderedSources{injections: map[string]*injection{}, parameterized: map[string]*parameterizedSource{}}
+	if r.Spec.Source != nil {
+		source, err := entry.sourceSpec(r.Spec.Source).resolve(items)
+		if err != nil {
+			return nil, fmt.Errorf("source: %w", err)
+		}
+		if err := rs.add("", source, r.baseDir()); err != nil {
+			return nil, fmt.Errorf("source: %w", err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, source := range r.Spec.Sources {
+		if source.Name == "" {
+			return nil, fmt.Errorf("sources must specify a name")
+		}
+		if seen[source.Name] {
+			return nil, fmt.Errorf("duplicate source %q", source.Name)
+		}
+		seen[source.Name] = true
+		spec, err := entry.sourceSpec(&source.SourceSpec).resolve(items)
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", source.Name, err)
+		}
+		if err := rs.add(source.Name, spec, r.baseDir()); err != nil {
+			return nil, fmt.Errorf("source %q: %w", source.Name, err)
+		}
+	}
+	return rs, nil
+}
+
+// apply injects the sources the target refers to into the target resources.
+func (t *TargetSpec) apply(items []*yaml.RNode, rs *renderedSources) ([]*yaml.RNode, error) {
+	if t.Shard != nil {
+		items, err := t.applyShards(items, rs)
+		if err != nil {
+			return nil, fmt.Errorf("target: %w", err)
+		}
+		return items, nil
+	}
+	setter, err := t.transform(rs)
+	if err != nil {
+		return nil, fmt.Errorf("target: %w", err)
+	}
+	items, err = transform.Apply(setter, items, []*transform.TargetSelector{t.selector(t.FieldPaths)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply replacements: %w", err)
+	}
+	return items, nil
+}
+
+// transform returns the transform injecting the sources the target refers to.
+func (t *TargetSpec) transform(rs *renderedSources) (transform.Transform, error) {
+	if len(t.Sources) == 0 {
+		if p, ok := rs.parameterized[t.Source]; ok {
+			return &parameterizedValue{source: p, options: t.Options}, nil
+		}
+	}
+	setter, err := t.setter(rs)
+	if err != nil {
+		return nil, err
+	}
+	setter.Options = t.Options
+	return setter, nil
+}
+
+// setter returns the transform injecting the content of the sources referred to.
+func (t *SourceRef) setter(rs *renderedSources) (*setValue, error) {
+	if t.Source != "" && len(t.Sources) > 0 {
+		return nil, fmt.Errorf("either source or sources must be specified")
+	}
+	names := t.Sources
+	if len(names) == 0 {
+		names = []string{t.Source}
+	}
+
+	injections := make([]*injection, 0, len(names))
+	for _, name := range names {
+		inj, err := rs.get(name)
+		if err != nil {
+			return nil, err
+		}
+		injections = append(injections, inj)
+	}
+	if len(injections) == 1 {
+		inj := injections[0]
+		return &setValue{Value: inj.value, Base64: inj.base64, Merge: inj.files, Commits: inj.commits()}, nil
+	}
+
+	// Several sources are concatenated as strings.
+	separator := "---\n"
+	if t.Separator != nil {
+		separator = *t.Separator
+	}
+	parts := make([]string, 0, len(injections))
+	var commits []string
+	for i, inj := range injections {
+		if inj.value.YNode().Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("source %q is structured and cannot be concatenated", names[i])
+		}
+		if inj.base64 {
+			return nil, fmt.Errorf("source %q is base64 encoded and cannot be concatenated", names[i])
+		}
+		parts = append(parts, inj.value.YNode().Value)
+		commits = append(commits, inj.commits()...)
+	}
+	return &setValue{Value: yaml.NewScalarRNode(strings.Join(parts, separator)), Commits: commits}, nil
+}
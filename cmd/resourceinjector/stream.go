@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	ktypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// streamAnnotations are set by kustomize on the resources passed to plugins.
+// They are dropped from the resources rendered as sources.
+var streamAnnotations = []string{
+	"kustomize.config.k8s.io/id",
+	string(kioutil.LegacyIndexAnnotation),
+	string(kioutil.LegacyPathAnnotation),
+	kioutil.LegacyIdAnnotation,
+}
+
+// FromStreamSpec selects resources of the stream passed to the plugin as the source.
+type FromStreamSpec struct {
+	ktypes.Selector `yaml:",inline" json:",inline"`
+	// Remove drops the selected resources from the output.
+	Remove bool `yaml:"remove,omitempty" json:"remove,omitempty"`
+}
+
+// resolve returns a copy of the source with the parts read from the resources
+// in the stream filled in, i.e. the values of its substitution and the
+// resources selected by fromStream.
+func (s *SourceSpec) resolve(items []*yaml.RNode) (*SourceSpec, error) {
+	if s.Substitute == nil && s.FromStream == nil {
+		return s, nil
+	}
+	source := *s
+	if s.Substitute != nil {
+		resolved, err := s.Substitute.resolve(items)
+		if err != nil {
+			return nil, err
+		}
+		source.Substitute = resolved
+	}
+	if s.FromStream != nil {
+		selected, err := transform.Select(items, &s.FromStream.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select resources from the stream: %w", err)
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no resources selected from the stream")
+		}
+		source.streamed = selected
+	}
+	return &source, nil
+}
+
+// streamSource returns copies of the resources selected from the stream, so
+// that injecting into the stream does not change the source.
+func streamSource(source *SourceSpec) ([]*yaml.RNode, error) {
+	switch {
+	case source.Path != "" || source.isRemote():
+		return nil, fmt.Errorf("fromStream cannot be used with path, git, archive, oci or url")
+	case source.Kustomization != nil, source.Options != nil, source.Cache != nil:
+		return nil, fmt.Errorf("kustomization, options and cache cannot be used with fromStream sources")
+	}
+	resources := make([]*yaml.RNode, 0, len(source.streamed))
+	for _, res := range source.streamed {
+		res = res.Copy()
+		if err := clearStreamAnnotations(res); err != nil {
+			return nil, err
+		}
+		resources = append(resources, res)
+	}
+	if source.Substitute == nil {
+		return resources, nil
+	}
+
+	content, err := documentsString(resources, yaml.DefaultIndent)
+	if err != nil {
+		return nil, err
+	}
+	substituted, err := source.Substitute.apply([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+	return parseDocuments(substituted)
+}
+
+// clearStreamAnnotations removes the annotations kustomize sets on the
+// resources of the stream.
+func clearStreamAnnotations(res *yaml.RNode) error {
+	annotations := res.GetAnnotations()
+	for key := range annotations {
+		if strings.HasPrefix(key, "internal.config.kubernetes.io/") {
+			delete(annotations, key)
+		}
+	}
+	for _, key := range streamAnnotations {
+		delete(annotations, key)
+	}
+	return res.SetAnnotations(annotations)
+}
+
+// withoutResources returns the items except the removed ones.
+func withoutResources(items, removed []*yaml.RNode) []*yaml.RNode {
+	if len(removed) == 0 {
+		return items
+	}
+	drop := make(map[*yaml.RNode]bool, len(removed))
+	for _, res := range removed {
+		drop[res] = true
+	}
+	kept := make([]*yaml.RNode, 0, len(items))
+	for _, item := range items {
+		if !drop[item] {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
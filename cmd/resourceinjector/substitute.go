@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/a8m/envsubst/parse"
+	"github.com/midiparse/kustomize-plugins/internal/transform"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// variableNameRegex matches the names of substituted variables.
+var variableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// placeholderRegex matches `$NAME` and `${NAME...}` placeholders, optionally
+// escaped with another `$`.
+var placeholderRegex = regexp.MustCompile(`\$(\$?)(?:\{([A-Za-z_][A-Za-z0-9_]*)([^}]*)\}|([A-Za-z_][A-Za-z0-9_]*))`)
+
+// SubstituteSpec replaces `${NAME}`-style placeholders in the rendered source.
+type SubstituteSpec struct {
+	// Strict fails on placeholders of unset variables instead of replacing them with an empty string.
+	Strict bool `yaml:"strict,omitempty" json:"strict,omitempty"`
+	// Vars are the variables substituted. Placeholders of other variables are left as they are.
+	Vars []*SubstituteVarSpec `yaml:"vars,omitempty" json:"vars,omitempty"`
+}
+
+// SubstituteVarSpec is a variable substituted in the rendered source. Without
+// a value or source, it is read from the environment.
+type SubstituteVarSpec struct {
+	Name string `yaml:"name" json:"name"`
+	// Value is the literal value of the variable.
+	Value *string `yaml:"value,omitempty" json:"value,omitempty"`
+	// Source reads the value from a field of a resource in the stream.
+	Source *transform.SourceSelector `yaml:"source,omitempty" json:"source,omitempty"`
+}
+
+// resolve returns a copy of the substitution with the values read from the
+// resources in the stream filled in as literal values.
+func (s *SubstituteSpec) resolve(items []*yaml.RNode) (*SubstituteSpec, error) {
+	if s == nil {
+		return nil, nil
+	}
+	resolved := &SubstituteSpec{Strict: s.Strict, Vars: make([]*SubstituteVarSpec, 0, len(s.Vars))}
+	seen := map[string]bool{}
+	for _, v := range s.Vars {
+		if !variableNameRegex.MatchString(v.Name) {
+			return nil, fmt.Errorf("invalid substitute variable name %q", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("duplicate substitute variable %q", v.Name)
+		}
+		seen[v.Name] = true
+		if v.Value != nil && v.Source != nil {
+			return nil, fmt.Errorf("substitute variable %q cannot specify both value and source", v.Name)
+		}
+		if v.Source == nil {
+			resolved.Vars = append(resolved.Vars, v)
+			continue
+		}
+		node, err := transform.SelectSourceNode(items, v.Source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select source for substitute variable %q: %w", v.Name, err)
+		}
+		if node.YNode().Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("source of substitute variable %q must be a scalar", v.Name)
+		}
+		value := node.YNode().Value
+		resolved.Vars = append(resolved.Vars, &SubstituteVarSpec{Name: v.Name, Value: &value})
+	}
+	return resolved, nil
+}
+
+// apply substitutes the placeholders of the variables in the content. Any
+// other text, including placeholders of unlisted variables, e.g. `$HOME` in
+// scripts, is left byte for byte as it is.
+func (s *SubstituteSpec) apply(content []byte) ([]byte, error) {
+	if s == nil {
+		return content, nil
+	}
+	listed := make(map[string]bool, len(s.Vars))
+	env := make([]string, 0, len(s.Vars))
+	for _, v := range s.Vars {
+		listed[v.Name] = true
+		if v.Value != nil {
+			env = append(env, v.Name+"="+*v.Value)
+		} else if value, ok := os.LookupEnv(v.Name); ok {
+			env = append(env, v.Name+"="+value)
+		}
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, m := range placeholderRegex.FindAllSubmatchIndex(content, -1) {
+		name := m[8:10]
+		if m[4] >= 0 {
+			name = m[4:6]
+		}
+		if !listed[string(content[name[0]:name[1]])] {
+			continue
+		}
+		out.Write(content[last:m[0]])
+		last = m[1]
+		if m[3] > m[2] {
+			// `$$` escapes the placeholder.
+			out.Write(content[m[0]+1 : m[1]])
+			continue
+		}
+		// Placeholders are evaluated one by one, so that the envsubst syntax applies to the listed variables only.
+		value, err := parse.New("source", env, &parse.Restrictions{NoUnset: s.Strict, NoDigit: true}).Parse(string(content[m[0]:m[1]]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to substitute variables: %w", err)
+		}
+		out.WriteString(value)
+	}
+	out.Write(content[last:])
+	return out.Bytes(), nil
+}
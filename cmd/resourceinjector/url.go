@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const (
+	// defaultFetchTimeout bounds fetching url sources, including reading the response.
+	defaultFetchTimeout = 30 * time.Second
+	// defaultFetchMaxSize is the largest url source fetched, in bytes.
+	defaultFetchMaxSize = 32 << 20
+)
+
+// sha256Regex matches hex encoded sha256 digests.
+var sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// FetchSpec configures fetching url sources.
+type FetchSpec struct {
+	// Timeout of the request, e.g. `10s`. Defaults to 30s.
+	Timeout string `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// MaxSize is the largest response accepted, in bytes. Defaults to 32 MiB.
+	MaxSize int64 `yaml:"maxSize,omitempty" json:"maxSize,omitempty"`
+	// Dir is the directory within a fetched archive to render.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+}
+
+// urlSource fetches the file or archive the url points to and verifies it
+// against its sha256 digest. Archives are unpacked into an in-memory file
+// system, other files are rendered like a local file. Fetched content is kept
+// in the cache, if enabled, and not fetched again.
+func urlSource(source *SourceSpec, cache *renderCache) (*sourceLocation, error) {
+	u, err := url.Parse(source.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url %q, must be http or https", source.URL)
+	}
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		return nil, fmt.Errorf("url %q must point to a file", source.URL)
+	}
+	if source.SHA256 == "" {
+		return nil, fmt.Errorf("sha256 must be specified for url sources")
+	}
+	digest := strings.ToLower(source.SHA256)
+	if !sha256Regex.MatchString(digest) {
+		return nil, fmt.Errorf("sha256 %q must be a hex encoded digest", source.SHA256)
+	}
+	fetch := source.Fetch
+	if fetch == nil {
+		fetch = &FetchSpec{}
+	}
+
+	var content []byte
+	var cachePath string
+	if cache != nil {
+		cachePath = filepath.Join(cache.dir, urlCacheDir, digest)
+		// Entries are verified, so that corrupted ones are fetched again.
+		if cached, err := os.ReadFile(cachePath); err == nil && verifyDigest(cached, "sha256:"+digest) == nil {
+			content = cached
+			touch(cachePath)
+		}
+	}
+	if content == nil {
+		content, err = fetchURL(source.URL, fetch)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyDigest(content, "sha256:"+digest); err != nil {
+			return nil, fmt.Errorf("url %q: %w", source.URL, err)
+		}
+		if cachePath != "" {
+			if err := writeFileAtomic(cachePath, content); err != nil {
+				return nil, err
+			}
+			if err := cache.evict(); err != nil {
+				return nil, fmt.Errorf("failed to evict from render cache: %w", err)
+			}
+		}
+	}
+
+	fSys := filesys.MakeFsInMemory()
+	sourcePath := filepath.Join(memSourceRoot, name)
+	if isArchive(name) {
+		if err := unpackArchive(name, content, fSys, memSourceRoot); err != nil {
+			return nil, fmt.Errorf("failed to unpack archive %q: %w", source.URL, err)
+		}
+		if sourcePath, err = subPath(memSourceRoot, fetch.Dir); err != nil {
+			return nil, err
+		}
+	} else {
+		if fetch.Dir != "" {
+			return nil, fmt.Errorf("fetch dir can only be used with archives")
+		}
+		if err := fSys.WriteFile(sourcePath, content); err != nil {
+			return nil, err
+		}
+	}
+	return &sourceLocation{
+		fSys:     fSys,
+		path:     sourcePath,
+		identity: "sha256:" + digest,
+		origin:   remoteOrigin(source.URL, "sha256:"+digest, fetch.Dir),
+		cleanup:  func() {},
+	}, nil
+}
+
+// fetchURL downloads the url, failing on responses other than 200 OK and on
+// responses larger than the size cap.
+func fetchURL(rawURL string, fetch *FetchSpec) ([]byte, error) {
+	timeout := defaultFetchTimeout
+	if fetch.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(fetch.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid fetch timeout: %w", err)
+		}
+	}
+	maxSize := int64(defaultFetchMaxSize)
+	if fetch.MaxSize < 0 {
+		return nil, fmt.Errorf("fetch maxSize must not be negative")
+	}
+	if fetch.MaxSize > 0 {
+		maxSize = fetch.MaxSize
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch url: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch url %q: %s", rawURL, resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("url %q exceeds the limit of %d bytes", rawURL, maxSize)
+	}
+	// Read one byte past the limit to detect larger responses of unknown length.
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch url: %w", err)
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("url %q exceeds the limit of %d bytes", rawURL, maxSize)
+	}
+	return content, nil
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/midiparse/kustomize-plugins/internal/transform"
-	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	"github.com/midiparse/kustomize-plugins/internal/yq"
 	goyaml "go.yaml.in/yaml/v3"
-	logging "gopkg.in/op/go-logging.v1"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework"
 	"sigs.k8s.io/kustomize/kyaml/fn/framework/command"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
@@ -18,7 +15,7 @@ import (
 
 func main() {
 	// Configure yq logging - suppress debug messages unless DEBUG env var is set
-	configureYqLogging()
+	yq.ConfigureLogging()
 
 	api := &API{}
 
@@ -35,15 +32,6 @@ func main() {
 	}
 }
 
-func configureYqLogging() {
-	debugEnabled := os.Getenv("DEBUG") != ""
-	logging.SetLevel(logging.ERROR, "yq-lib") // Default to ERROR level
-	if debugEnabled {
-		logging.SetLevel(logging.DEBUG, "yq-lib")
-		return
-	}
-}
-
 // YqTransformSpec defines the configuration for the yq transformer.
 type YqTransformSpec struct {
 	Source  *Source                     `yaml:"source,omitempty" json:"source,omitempty"`
@@ -84,13 +72,11 @@ func (r *API) Filter(items []*yaml.RNode) ([]*yaml.RNode, error) {
 		return nil, fmt.Errorf("failed to prepare yq vars: %w", err)
 	}
 
-	yq := &yqTransform{
-		Expression: r.Spec.Source.Expression,
-		Evaluator:  yqlib.NewAllAtOnceEvaluator(),
-		Variables:  vars,
+	yqt := &yqTransform{
+		Evaluator: yq.NewEvaluator(r.Spec.Source.Expression, vars),
 	}
 
-	items, err = transform.Apply(yq, items, r.Spec.Targets)
+	items, err = transform.Apply(yqt, items, r.Spec.Targets)
 	if err != nil {
 		return nil, fmt.Errorf("failed to apply yq: %w", err)
 	}
@@ -150,108 +136,22 @@ func sourceValueToNode(value string) (*goyaml.Node, error) {
 	return docNode, nil
 }
 
-func toCandidateNode(node *goyaml.Node) (*yqlib.CandidateNode, error) {
-	var res yqlib.CandidateNode
-	if err := res.UnmarshalYAML(node, nil); err != nil {
-		return nil, err
-	}
-	return &res, nil
-}
-
 type yqTransform struct {
-	Expression string
-	Evaluator  yqlib.Evaluator
-	Context    context.Context
-	Variables  map[string]*goyaml.Node
+	Evaluator *yq.Evaluator
 }
 
 func (s *yqTransform) CreateKind() yaml.Kind {
 	return yaml.ScalarNode // Create a null scalar node to support node creation
 }
 
-func (s *yqTransform) Apply(target *yaml.RNode) error {
-	expr, node := wrapInVariableContext(s.Expression, target.YNode(), s.Variables)
-
-	inputNode, err := toCandidateNode(node)
-	if err != nil {
-		return fmt.Errorf("failed to create input node for expression: %w", err)
-	}
-
-	// Evaluate the expression
-	result, err := s.Evaluator.EvaluateNodes(expr, inputNode)
-	if err != nil {
-		return fmt.Errorf("failed to evaluate expression: %w", err)
-	}
-
-	if result.Len() == 0 {
-		return fmt.Errorf("expression produced no results")
-	}
-
-	// Get the first result from the list
-	firstResult := result.Front()
-	if firstResult == nil {
-		return fmt.Errorf("expression produced empty result")
-	}
-
-	resultCandidate, ok := firstResult.Value.(*yqlib.CandidateNode)
-	if !ok {
-		return fmt.Errorf("unexpected result type")
-	}
-
-	outNode, err := resultCandidate.MarshalYAML()
+func (s *yqTransform) Apply(t *transform.Target) error {
+	outNode, err := s.Evaluator.Evaluate(t.Field.YNode())
 	if err != nil {
-		return fmt.Errorf("failed to marshal yq result: %w", err)
+		return err
 	}
 
 	// Replace the target node's content with the transformed content
-	target.SetYNode(outNode)
+	t.Field.SetYNode(outNode)
 
 	return nil
 }
-
-func wrapInVariableContext(expression string, node *goyaml.Node, vars map[string]*goyaml.Node) (string, *goyaml.Node) {
-	if len(vars) == 0 {
-		// No variables to wrap
-		return expression, node
-	}
-	// Create the 'vars' mapping node
-	varsMapNode := &goyaml.Node{Kind: goyaml.MappingNode}
-	for k, v := range vars {
-		keyNode := &goyaml.Node{
-			Kind:  goyaml.ScalarNode,
-			Tag:   "!!str",
-			Value: k,
-		}
-		// Ensure we are appending the actual yaml.Node from the CandidateNode
-		varsMapNode.Content = append(varsMapNode.Content, keyNode, v)
-	}
-
-	// Create the top-level wrapper object
-	wrapperNode := &goyaml.Node{
-		Kind: goyaml.MappingNode,
-		Content: []*goyaml.Node{
-			{
-				Kind:  goyaml.ScalarNode,
-				Tag:   "!!str",
-				Value: "target",
-			},
-			node,
-			{
-				Kind:  goyaml.ScalarNode,
-				Tag:   "!!str",
-				Value: "vars",
-			},
-			varsMapNode,
-		},
-	}
-
-	// Wrap the user expression
-	var wrappedExpression strings.Builder
-	for varName := range vars {
-		wrappedExpression.WriteString(fmt.Sprintf(".vars.%s as $%s | ", varName, varName))
-	}
-
-	finalExpression := fmt.Sprintf(".target | %s", expression)
-	wrappedExpression.WriteString(finalExpression)
-	return wrappedExpression.String(), wrapperNode
-}
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
 	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
@@ -26,6 +27,20 @@ func TestE2E(t *testing.T, path string) {
 			testDir := filepath.Join(path, testName)
 			fixtureDir := filepath.Join(testDir, "fixture")
 			outPath := filepath.Join(testDir, "out.yaml")
+			errPath := filepath.Join(testDir, "error.txt")
+			envPath := filepath.Join(testDir, "env.txt")
+
+			// Fixtures reading the environment list the variables they need in env.txt
+			if env, readErr := os.ReadFile(envPath); readErr == nil {
+				for _, line := range strings.Split(string(env), "\n") {
+					if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+						continue
+					}
+					name, value, ok := strings.Cut(line, "=")
+					require.True(t, ok, "invalid line %q in env.txt, must be NAME=value", line)
+					t.Setenv(name, value)
+				}
+			}
 
 			opts := krusty.MakeDefaultOptions()
 			opts.PluginConfig = &types.PluginConfig{
@@ -36,8 +51,21 @@ func TestE2E(t *testing.T, path string) {
 			}
 			kustomizer := krusty.MakeKustomizer(opts)
 			fSys := filesys.MakeFsOnDisk()
-			resMap, err := kustomizer.Run(fSys, fixtureDir)
-			require.NoError(t, err)
+			var (
+				resMap resmap.ResMap
+				err    error
+			)
+			stderr := CaptureStderr(t, func() {
+				resMap, err = kustomizer.Run(fSys, fixtureDir)
+			})
+
+			// Fixtures expected to fail list the expected plugin error in error.txt
+			if expectedErr, readErr := os.ReadFile(errPath); readErr == nil {
+				require.Error(t, err)
+				assert.Contains(t, stderr, strings.TrimSpace(string(expectedErr)), "plugin error does not match error.txt")
+				return
+			}
+			require.NoError(t, err, stderr)
 			yaml, err := resMap.AsYaml()
 			require.NoError(t, err)
 
@@ -64,3 +92,20 @@ func TestE2E(t *testing.T, path string) {
 		})
 	}
 }
+
+// CaptureStderr returns everything written to stderr while running fn,
+// including the output of exec plugins.
+func CaptureStderr(t *testing.T, fn func()) string {
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	require.NoError(t, err)
+	defer f.Close()
+
+	orig := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = orig }()
+	fn()
+
+	out, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	return string(out)
+}
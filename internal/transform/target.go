@@ -7,7 +7,6 @@ import (
 	"sigs.k8s.io/kustomize/api/resource"
 	ktypes "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/errors"
-	"sigs.k8s.io/kustomize/kyaml/resid"
 	kyaml_utils "sigs.k8s.io/kustomize/kyaml/utils"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 )
@@ -15,7 +14,17 @@ import (
 // Transform defines an interface for applying transformations to YAML nodes.
 type Transform interface {
 	CreateKind() yaml.Kind
-	Apply(target *yaml.RNode) error
+	Apply(target *Target) error
+}
+
+// Target is a field selected for transformation, along with the resource it belongs to.
+type Target struct {
+	// Resource is the resource containing the field.
+	Resource *yaml.RNode
+	// Field is the selected field.
+	Field *yaml.RNode
+	// FieldPath is the split field path the field was selected with.
+	FieldPath []string
 }
 
 // TargetSelector defines the criteria for selecting and modifying target resources.
@@ -46,34 +55,61 @@ func Apply(transform Transform, nodes []*yaml.RNode, targetSelectors []*TargetSe
 			return nil, fmt.Errorf("error creating target selector: %w", err)
 		}
 		for _, possibleTarget := range nodes {
-			ids, err := utils.MakeResIds(possibleTarget)
+			selected, err := isSelected(possibleTarget, tsr.selectRegex, selector.Select)
 			if err != nil {
 				return nil, err
 			}
-
-			// filter targets by label and annotation selectors
-			selectByAnnoAndLabel, err := selectByAnnoAndLabel(possibleTarget, selector)
-			if err != nil {
-				return nil, err
-			}
-			if !selectByAnnoAndLabel {
+			if !selected {
 				continue
 			}
-
-			for _, id := range ids {
-				if tsr.Selects(id) {
-					err := applyTransformToTarget(transform, possibleTarget, selector)
-					if err != nil {
-						return nil, err
-					}
-					break
-				}
+			if err := applyTransformToTarget(transform, possibleTarget, selector); err != nil {
+				return nil, err
 			}
 		}
 	}
 	return nodes, nil
 }
 
+// Select returns the nodes matched by the given selector, preserving their order.
+func Select(nodes []*yaml.RNode, selector *ktypes.Selector) ([]*yaml.RNode, error) {
+	selectRegex, err := ktypes.NewSelectorRegex(selector)
+	if err != nil {
+		return nil, fmt.Errorf("error creating selector: %w", err)
+	}
+	var result []*yaml.RNode
+	for _, n := range nodes {
+		selected, err := isSelected(n, selectRegex, selector)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			result = append(result, n)
+		}
+	}
+	return result, nil
+}
+
+// isSelected reports whether any of the node's current or previous ids, as well
+// as its labels and annotations, match the selector.
+func isSelected(n *yaml.RNode, selectRegex *ktypes.SelectorRegex, selector *ktypes.Selector) (bool, error) {
+	// filter by label and annotation selectors
+	matchesAnnoAndLabel, err := matchesAnnoAndLabelSelector(n, selector)
+	if err != nil || !matchesAnnoAndLabel {
+		return false, err
+	}
+
+	ids, err := utils.MakeResIds(n)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if selectRegex.MatchGvk(id.Gvk) && selectRegex.MatchName(id.Name) && selectRegex.MatchNamespace(id.Namespace) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 type targetSelectorRegex struct {
 	targetSelector *TargetSelector
 	selectRegex    *ktypes.SelectorRegex
@@ -92,17 +128,6 @@ func newTargetSelectorRegex(ts *TargetSelector) (*targetSelectorRegex, error) {
 	return tsr, nil
 }
 
-func (tsr *targetSelectorRegex) Selects(id resid.ResId) bool {
-	return tsr.selectRegex.MatchGvk(id.Gvk) && tsr.selectRegex.MatchName(id.Name) && tsr.selectRegex.MatchNamespace(id.Namespace)
-}
-
-func selectByAnnoAndLabel(n *yaml.RNode, t *TargetSelector) (bool, error) {
-	if matchesSelect, err := matchesAnnoAndLabelSelector(n, t.Select); !matchesSelect || err != nil {
-		return false, err
-	}
-	return true, nil
-}
-
 func matchesAnnoAndLabelSelector(n *yaml.RNode, selector *ktypes.Selector) (bool, error) {
 	r := resource.Resource{RNode: *n}
 	annoMatch, err := r.MatchesAnnotationSelector(selector.AnnotationSelector)
@@ -122,8 +147,9 @@ func applyTransformToTarget(transform Transform, target *yaml.RNode, selector *T
 		if selector.Options != nil && selector.Options.Create {
 			createKind = transform.CreateKind()
 		}
+		fieldPath := kyaml_utils.SmarterPathSplitter(fp, ".")
 		targetFieldList, err := target.Pipe(&yaml.PathMatcher{
-			Path:   kyaml_utils.SmarterPathSplitter(fp, "."),
+			Path:   fieldPath,
 			Create: createKind})
 		if err != nil {
 			return errors.WrapPrefixf(err, "%s", fieldRetrievalError(fp, createKind != 0))
@@ -134,7 +160,12 @@ func applyTransformToTarget(transform Transform, target *yaml.RNode, selector *T
 		}
 
 		for _, t := range targetFields {
-			if err := transform.Apply(t); err != nil {
+			err := transform.Apply(&Target{
+				Resource:  target,
+				Field:     t,
+				FieldPath: fieldPath,
+			})
+			if err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,131 @@
+package yq
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	goyaml "go.yaml.in/yaml/v3"
+	logging "gopkg.in/op/go-logging.v1"
+)
+
+// ConfigureLogging suppresses yq debug messages unless the DEBUG env var is set.
+func ConfigureLogging() {
+	debugEnabled := os.Getenv("DEBUG") != ""
+	logging.SetLevel(logging.ERROR, "yq-lib") // Default to ERROR level
+	if debugEnabled {
+		logging.SetLevel(logging.DEBUG, "yq-lib")
+		return
+	}
+}
+
+// Evaluator evaluates a yq expression against YAML nodes.
+type Evaluator struct {
+	Expression string
+	Variables  map[string]*goyaml.Node
+	evaluator  yqlib.Evaluator
+}
+
+// NewEvaluator returns an Evaluator for the expression. The variables are
+// available in the expression as `$name`.
+func NewEvaluator(expression string, vars map[string]*goyaml.Node) *Evaluator {
+	return &Evaluator{
+		Expression: expression,
+		Variables:  vars,
+		evaluator:  yqlib.NewAllAtOnceEvaluator(),
+	}
+}
+
+// Evaluate evaluates the expression against the node and returns the first result.
+func (e *Evaluator) Evaluate(node *goyaml.Node) (*goyaml.Node, error) {
+	expr, node := wrapInVariableContext(e.Expression, node, e.Variables)
+
+	inputNode, err := ToCandidateNode(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input node for expression: %w", err)
+	}
+
+	// Evaluate the expression
+	result, err := e.evaluator.EvaluateNodes(expr, inputNode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate expression: %w", err)
+	}
+
+	if result.Len() == 0 {
+		return nil, fmt.Errorf("expression produced no results")
+	}
+
+	// Get the first result from the list
+	firstResult := result.Front()
+	if firstResult == nil {
+		return nil, fmt.Errorf("expression produced empty result")
+	}
+
+	resultCandidate, ok := firstResult.Value.(*yqlib.CandidateNode)
+	if !ok {
+		return nil, fmt.Errorf("unexpected result type")
+	}
+
+	outNode, err := resultCandidate.MarshalYAML()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal yq result: %w", err)
+	}
+	return outNode, nil
+}
+
+// ToCandidateNode converts a YAML node into a yq candidate node.
+func ToCandidateNode(node *goyaml.Node) (*yqlib.CandidateNode, error) {
+	var res yqlib.CandidateNode
+	if err := res.UnmarshalYAML(node, nil); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func wrapInVariableContext(expression string, node *goyaml.Node, vars map[string]*goyaml.Node) (string, *goyaml.Node) {
+	if len(vars) == 0 {
+		// No variables to wrap
+		return expression, node
+	}
+	// Create the 'vars' mapping node
+	varsMapNode := &goyaml.Node{Kind: goyaml.MappingNode}
+	for k, v := range vars {
+		keyNode := &goyaml.Node{
+			Kind:  goyaml.ScalarNode,
+			Tag:   "!!str",
+			Value: k,
+		}
+		// Ensure we are appending the actual yaml.Node from the CandidateNode
+		varsMapNode.Content = append(varsMapNode.Content, keyNode, v)
+	}
+
+	// Create the top-level wrapper object
+	wrapperNode := &goyaml.Node{
+		Kind: goyaml.MappingNode,
+		Content: []*goyaml.Node{
+			{
+				Kind:  goyaml.ScalarNode,
+				Tag:   "!!str",
+				Value: "target",
+			},
+			node,
+			{
+				Kind:  goyaml.ScalarNode,
+				Tag:   "!!str",
+				Value: "vars",
+			},
+			varsMapNode,
+		},
+	}
+
+	// Wrap the user expression
+	var wrappedExpression strings.Builder
+	for varName := range vars {
+		wrappedExpression.WriteString(fmt.Sprintf(".vars.%s as $%s | ", varName, varName))
+	}
+
+	finalExpression := fmt.Sprintf(".target | %s", expression)
+	wrappedExpression.WriteString(finalExpression)
+	return wrappedExpression.String(), wrapperNode
+}
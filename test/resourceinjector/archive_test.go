@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/midiparse/kustomize-plugins/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zeros reads the given number of zero bytes.
+type zeros int64
+
+func (z *zeros) Read(p []byte) (int, error) {
+	if *z <= 0 {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if n > int64(*z) {
+		n = int64(*z)
+	}
+	clear(p[:n])
+	*z -= zeros(n)
+	return int(n), nil
+}
+
+// writeBomb writes a small gzipped tarball holding a file of the given size.
+func writeBomb(t *testing.T, path string, size int64) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	require.NoError(t, err)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bundle/big.yaml", Mode: 0o644, Size: size, Typeflag: tar.TypeReg}))
+	content := zeros(size)
+	_, err = io.Copy(tw, &content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func TestArchiveSourceUnpackedSize(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "bomb.tar.gz")
+	writeBomb(t, archive, 256<<20+1)
+	content, err := os.ReadFile(archive)
+	require.NoError(t, err)
+
+	var buildErr error
+	stderr := testutils.CaptureStderr(t, func() {
+		_, buildErr = buildSourceFixture(t, dir,
+			"    archive:\n      path: ./bomb.tar.gz\n      digest: sha256:"+sha256Hex(content)+"\n      dir: bundle")
+	})
+	require.Error(t, buildErr)
+	assert.Contains(t, stderr, "archive exceeds the limit of 268435456 bytes once unpacked")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cacheInjector = `apiVersion: kustomize-plugins.midiparse.github.com/v1alpha1
+kind: ResourceInjector
+metadata:
+  name: inject-inner
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: kustomize-plugin-resourceinjector
+spec:
+  sources:
+  - name: first
+    path: ./inner
+  - name: second
+    path: ./inner
+    select:
+      kind: Service
+  targets:
+  - select:
+      kind: ConfigMap
+    fieldPaths:
+    - data.[first.yaml]
+    options:
+      create: true
+    source: first
+  - select:
+      kind: ConfigMap
+    fieldPaths:
+    - data.[second.yaml]
+    options:
+      create: true
+    source: second
+`
+
+// writeCacheFixture writes a kustomization injecting the inner kustomization
+// into a ConfigMap.
+func writeCacheFixture(t *testing.T, dir string, injector string) {
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml":       fixtureKustomization,
+		"inject-inner.yaml":        injector,
+		"configmap.yaml":           "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+		"inner/kustomization.yaml": "resources:\n- service.yaml\n",
+		"inner/service.yaml":       "apiVersion: v1\nkind: Service\nmetadata:\n  name: backend\n",
+	})
+}
+
+func buildCacheFixture(t *testing.T, dir string) string {
+	out, err := buildKustomization(t, dir)
+	require.NoError(t, err)
+	return out
+}
+
+func cacheEntries(t *testing.T, cacheDir string) []string {
+	entries, err := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	require.NoError(t, err)
+	return entries
+}
+
+func TestRenderCache(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	t.Setenv("RESOURCEINJECTOR_CACHE_DIR", cacheDir)
+	writeCacheFixture(t, filepath.Join(dir, "app"), cacheInjector)
+
+	out := buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: backend")
+	entries := cacheEntries(t, cacheDir)
+	require.Len(t, entries, 1, "sources with the same path and options share an entry")
+
+	// Unchanged sources are served from the cache.
+	content, err := os.ReadFile(entries[0])
+	require.NoError(t, err)
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(content, &entry))
+	cached := "apiVersion: v1\nkind: Service\nmetadata:\n  name: cached\n"
+	entry["output"] = []byte(cached)
+	content, err = json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(entries[0], content, 0o644))
+	out = buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: cached")
+
+	// Changing a loaded file invalidates the entry.
+	servicePath := filepath.Join(dir, "app", "inner", "service.yaml")
+	require.NoError(t, os.WriteFile(servicePath, []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: changed\n"), 0o644))
+	out = buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: changed")
+	assert.NotContains(t, out, "name: cached")
+}
+
+func TestRenderCacheExternalPrograms(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	t.Setenv("RESOURCEINJECTOR_CACHE_DIR", cacheDir)
+	options := "    path: ./inner\n    options:\n      pluginConfig:\n        pluginRestrictions: none\n" +
+		"        fnpLoadingOptions:\n          enableExec: true\n"
+	writeCacheFixture(t, filepath.Join(dir, "app"), strings.ReplaceAll(cacheInjector, "    path: ./inner\n", options))
+
+	out := buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: backend")
+	assert.Empty(t, cacheEntries(t, cacheDir), "builds that may run exec plugins are not cached")
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	injector := strings.ReplaceAll(cacheInjector, "    path: ./inner\n", "    path: ./inner\n    cache:\n      dir: ../cache\n      maxEntries: 1\n")
+	// A different inline kustomization renders a separate entry.
+	injector = strings.Replace(injector, "    select:\n      kind: Service\n", "    select:\n      kind: Service\n    kustomization:\n      namePrefix: second-\n", 1)
+	writeCacheFixture(t, filepath.Join(dir, "app"), injector)
+
+	out := buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: second-backend")
+	assert.Len(t, cacheEntries(t, cacheDir), 1)
+}
+
+func TestRenderCacheEvictionRemoteSources(t *testing.T) {
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	t.Setenv("RESOURCEINJECTOR_CACHE_DIR", cacheDir)
+	writeCacheFixture(t, filepath.Join(dir, "app"), cacheInjector)
+
+	stale := time.Now().Add(-30 * 24 * time.Hour)
+	writeFiles(t, cacheDir, map[string]string{
+		"git/0123abcd-stale/.git/HEAD":    "ref: refs/heads/main\n",
+		"git/.tmp-in-progress/checkout":   "",
+		"url/" + sha256Hex([]byte("old")): "old",
+	})
+	for _, name := range []string{"git/0123abcd-stale", "git/.tmp-in-progress", "url/" + sha256Hex([]byte("old"))} {
+		require.NoError(t, os.Chtimes(filepath.Join(cacheDir, name), stale, stale))
+	}
+
+	out := buildCacheFixture(t, filepath.Join(dir, "app"))
+	assert.Contains(t, out, "name: backend")
+	assert.NoDirExists(t, filepath.Join(cacheDir, "git", "0123abcd-stale"))
+	assert.NoFileExists(t, filepath.Join(cacheDir, "url", sha256Hex([]byte("old"))))
+	assert.DirExists(t, filepath.Join(cacheDir, "git", ".tmp-in-progress"), "checkouts in progress are left alone")
+}
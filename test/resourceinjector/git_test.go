@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midiparse/kustomize-plugins/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_DATE=2024-01-01T00:00:00Z",
+		"GIT_COMMITTER_DATE=2024-01-01T00:00:00Z",
+		"GIT_CONFIG_GLOBAL=/dev/null",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// writeGitRepo creates a repository with a tagged and a later commit of a
+// kustomization, returning their SHAs.
+func writeGitRepo(t *testing.T, dir string) (string, string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "inner"), 0o755))
+	git(t, dir, "init", "--quiet", "--initial-branch=main")
+	commit := func(name string) string {
+		service := "apiVersion: v1\nkind: Service\nmetadata:\n  name: " + name + "\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "inner", "kustomization.yaml"), []byte("resources:\n- service.yaml\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "inner", "service.yaml"), []byte(service), 0o644))
+		git(t, dir, "add", "-A")
+		git(t, dir, "commit", "--quiet", "-m", name)
+		return git(t, dir, "rev-parse", "HEAD")
+	}
+	tagged := commit("tagged")
+	git(t, dir, "tag", "-a", "v1", "-m", "v1")
+	latest := commit("latest")
+	return tagged, latest
+}
+
+func buildGitFixture(t *testing.T, dir, spec string) (string, error) {
+	return buildSourceFixture(t, dir, "    git:\n"+spec)
+}
+
+func TestGitSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+	repo := filepath.Join(app, "repo")
+	tagged, latest := writeGitRepo(t, repo)
+
+	tests := []struct {
+		name     string
+		spec     string
+		expected string
+		commit   string
+		err      string
+	}{
+		{
+			name:     "head",
+			spec:     "      repo: ./repo\n      dir: inner",
+			expected: "name: latest",
+			commit:   latest,
+		},
+		{
+			name:     "tag",
+			spec:     "      repo: file://" + filepath.ToSlash(repo) + "\n      ref: v1\n      dir: inner",
+			expected: "name: tagged",
+			commit:   tagged,
+		},
+		{
+			name:     "branch pinned",
+			spec:     "      repo: ./repo\n      ref: main\n      commit: " + latest + "\n      dir: inner",
+			expected: "name: latest",
+			commit:   latest,
+		},
+		{
+			name:     "commit",
+			spec:     "      repo: ./repo\n      commit: " + tagged + "\n      dir: inner",
+			expected: "name: tagged",
+			commit:   tagged,
+		},
+		{
+			name:     "field path",
+			spec:     "      repo: ./repo\n      dir: inner\n    fieldPath: metadata",
+			expected: "name: latest",
+			commit:   latest,
+		},
+		{
+			name: "pin mismatch",
+			spec: "      repo: ./repo\n      ref: v1\n      commit: " + latest + "\n      dir: inner",
+			err:  `git ref "v1" resolves to ` + tagged + ", not the pinned commit " + latest,
+		},
+		{
+			name: "unknown ref",
+			spec: "      repo: ./repo\n      ref: missing",
+			err:  `git ref "missing" not found`,
+		},
+		{
+			name: "ext protocol",
+			spec: "      repo: 'ext::touch " + filepath.Join(dir, "pwned") + "'\n      ref: main",
+			err:  "transport 'ext' not allowed",
+		},
+		{
+			name: "dir outside",
+			spec: "      repo: ./repo\n      dir: ../inner",
+			err:  `dir "../inner" must be relative to the root of the source`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out string
+			var err error
+			stderr := testutils.CaptureStderr(t, func() {
+				out, err = buildGitFixture(t, app, tt.spec)
+			})
+			assert.NoFileExists(t, filepath.Join(dir, "pwned"), "the repo must not run commands")
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, stderr, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, out, tt.expected)
+			// The commit is recorded on the target, leaving the injected content as is.
+			assert.Contains(t, out, "kustomize-plugins.midiparse.github.com/git-commit: "+tt.commit)
+			assert.Equal(t, 1, strings.Count(out, "git-commit"))
+		})
+	}
+}
+
+func TestGitSourceCache(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	t.Setenv("RESOURCEINJECTOR_CACHE_DIR", cacheDir)
+	app := filepath.Join(dir, "app")
+	tagged, latest := writeGitRepo(t, filepath.Join(app, "repo"))
+
+	out, err := buildGitFixture(t, app, "      repo: ./repo\n      dir: inner")
+	require.NoError(t, err)
+	assert.Contains(t, out, "name: latest")
+	checkouts, err := filepath.Glob(filepath.Join(cacheDir, "git", "*-"+latest))
+	require.NoError(t, err)
+	require.Len(t, checkouts, 1, "the commit is checked out into the cache")
+
+	// Cached checkouts are reused as they are.
+	service := filepath.Join(checkouts[0], "inner", "service.yaml")
+	require.NoError(t, os.WriteFile(service, []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: cached\n"), 0o644))
+	out, err = buildGitFixture(t, app, "      repo: ./repo\n      dir: inner")
+	require.NoError(t, err)
+	assert.Contains(t, out, "name: cached")
+
+	// Cached checkouts of another commit are replaced.
+	git(t, checkouts[0], "checkout", "--quiet", "--force", "--detach", tagged)
+	out, err = buildGitFixture(t, app, "      repo: ./repo\n      dir: inner")
+	require.NoError(t, err)
+	assert.Contains(t, out, "name: latest")
+}
+
+func TestGitSourceCycle(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app")
+	repo := filepath.Join(dir, "repo")
+	// Each render checks the repository out into a new directory, so the
+	// cycle is only found by keying the chain on the repository and commit.
+	source := "    git:\n      repo: " + repo + "\n      ref: main\n" +
+		"    options:\n      pluginConfig:\n        pluginRestrictions: none\n        fnpLoadingOptions:\n          enableExec: true"
+	writeFiles(t, repo, map[string]string{
+		"kustomization.yaml": fixtureKustomization,
+		"inject-inner.yaml":  strings.Replace(sourceInjector, "%s", source, 1),
+		"configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+	})
+	git(t, repo, "init", "--quiet", "--initial-branch=main")
+	git(t, repo, "add", "-A")
+	git(t, repo, "commit", "--quiet", "-m", "cycle")
+	commit := git(t, repo, "rev-parse", "HEAD")
+
+	var err error
+	stderr := testutils.CaptureStderr(t, func() {
+		_, err = buildSourceFixture(t, app, source)
+	})
+	require.Error(t, err)
+	assert.Contains(t, stderr, "source cycle detected: ../repo@"+commit+" -> ../repo@"+commit)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// fixtureKustomization runs inject-inner.yaml over a ConfigMap.
+const fixtureKustomization = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+- configmap.yaml
+
+transformers:
+- inject-inner.yaml
+`
+
+// sourceInjector injects the source, in place of %s, into a ConfigMap.
+const sourceInjector = `apiVersion: kustomize-plugins.midiparse.github.com/v1alpha1
+kind: ResourceInjector
+metadata:
+  name: inject-inner
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: kustomize-plugin-resourceinjector
+spec:
+  sources:
+  - name: source
+%s
+  targets:
+  - select:
+      kind: ConfigMap
+    fieldPaths:
+    - data.[source.yaml]
+    options:
+      create: true
+    source: source
+`
+
+// writeFiles writes the files, keyed by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+// buildKustomization builds the kustomization in dir with exec plugins enabled,
+// like the fixtures.
+func buildKustomization(t *testing.T, dir string) (string, error) {
+	opts := krusty.MakeDefaultOptions()
+	opts.PluginConfig = &types.PluginConfig{
+		PluginRestrictions: types.PluginRestrictionsNone,
+		FnpLoadingOptions:  types.FnPluginLoadingOptions{EnableExec: true},
+	}
+	resMap, err := krusty.MakeKustomizer(opts).Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return "", err
+	}
+	out, err := resMap.AsYaml()
+	require.NoError(t, err)
+	return string(out), nil
+}
+
+// buildSourceFixture builds a kustomization injecting the source into a ConfigMap.
+func buildSourceFixture(t *testing.T, dir, source string) (string, error) {
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml": fixtureKustomization,
+		"inject-inner.yaml":  strings.Replace(sourceInjector, "%s", source, 1),
+		"configmap.yaml":     "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+	})
+	return buildKustomization(t, dir)
+}
@@ -0,0 +1,2 @@
+#!/bin/sh
+exec app --config /etc/app/app.yaml
@@ -0,0 +1,2 @@
+#!/bin/sh
+kill "$(cat /run/app.pid)"
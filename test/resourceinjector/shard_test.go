@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/midiparse/kustomize-plugins/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const shardInjector = `apiVersion: kustomize-plugins.midiparse.github.com/v1alpha1
+kind: ResourceInjector
+metadata:
+  name: inject-inner
+  annotations:
+    config.kubernetes.io/function: |
+      exec:
+        path: kustomize-plugin-resourceinjector
+spec:
+  source:
+    path: ./inner
+  targets:
+  - select:
+      kind: ConfigMap
+    fieldPaths:
+    - %s
+    shard: {}
+`
+
+// writeShardFixture writes a kustomization sharding count documents of size
+// bytes each into the target field of a ConfigMap.
+func writeShardFixture(t *testing.T, dir, fieldPath string, count, size int) {
+	docs := make([]string, count)
+	for i := range docs {
+		docs[i] = fmt.Sprintf("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings-%d\ndata:\n  value: %s\n",
+			i, strings.Repeat("x", size))
+	}
+	writeFiles(t, dir, map[string]string{
+		"kustomization.yaml":       fixtureKustomization,
+		"inject-inner.yaml":        fmt.Sprintf(shardInjector, fieldPath),
+		"configmap.yaml":           "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n",
+		"inner/kustomization.yaml": "resources:\n- configmaps.yaml\n",
+		"inner/configmaps.yaml":    strings.Join(docs, "---\n"),
+	})
+}
+
+func TestShardTargetLimit(t *testing.T) {
+	t.Run("fits", func(t *testing.T) {
+		dir := t.TempDir()
+		writeShardFixture(t, dir, "metadata.annotations.[settings.yaml]", 4, 32*1024)
+		out, err := buildKustomization(t, dir)
+		require.NoError(t, err)
+		assert.Contains(t, out, "settings-0.yaml: |")
+		assert.NotContains(t, out, "settings-1.yaml")
+	})
+
+	t.Run("annotations", func(t *testing.T) {
+		dir := t.TempDir()
+		writeShardFixture(t, dir, "metadata.annotations.[settings.yaml]", 12, 32*1024)
+		var err error
+		stderr := testutils.CaptureStderr(t, func() {
+			_, err = buildKustomization(t, dir)
+		})
+		require.Error(t, err)
+		assert.Contains(t, stderr, "exceeding the limit of 262144 bytes of the target resource")
+	})
+
+	t.Run("data", func(t *testing.T) {
+		dir := t.TempDir()
+		writeShardFixture(t, dir, "data.[settings.yaml]", 40, 32*1024)
+		var err error
+		stderr := testutils.CaptureStderr(t, func() {
+			_, err = buildKustomization(t, dir)
+		})
+		require.Error(t, err)
+		assert.Contains(t, stderr, "exceeding the limit of 1048576 bytes of the target resource")
+	})
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+echo "$$" "$HOME" "$$REGION" > /tmp/backend.pid
+exec backend --region=${REGION} --tier=${TIER:-default} "$1"
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/midiparse/kustomize-plugins/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const urlService = "apiVersion: v1\nkind: Service\nmetadata:\n  name: fetched\n"
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// serveSources serves a single file, an archive, a large file and a slow
+// file, counting the requests.
+func serveSources(t *testing.T) (*httptest.Server, *int32) {
+	archive, err := os.ReadFile(filepath.Join("archive", "fixture", "bundle.tar.gz"))
+	require.NoError(t, err)
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/service.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(urlService))
+	})
+	mux.HandleFunc("/bundle.tar.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	})
+	mux.HandleFunc("/large.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(urlService + "# " + strings.Repeat("x", 2048) + "\n"))
+	})
+	mux.HandleFunc("/slow.yaml", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestURLSource(t *testing.T) {
+	server, _ := serveSources(t)
+	archive, err := os.ReadFile(filepath.Join("archive", "fixture", "bundle.tar.gz"))
+	require.NoError(t, err)
+	serviceSHA := sha256Hex([]byte(urlService))
+	app := t.TempDir()
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		err      string
+	}{
+		{
+			name:     "file",
+			source:   "    url: " + server.URL + "/service.yaml\n    sha256: " + serviceSHA,
+			expected: "name: fetched",
+		},
+		{
+			name: "archive",
+			source: "    url: " + server.URL + "/bundle.tar.gz?version=1\n    sha256: " + sha256Hex(archive) +
+				"\n    fetch:\n      dir: bundle",
+			expected: "name: backend",
+		},
+		{
+			name:   "sha256 mismatch",
+			source: "    url: " + server.URL + "/service.yaml\n    sha256: '" + strings.Repeat("0", 64) + "'",
+			err:    "digest mismatch: expected sha256:" + strings.Repeat("0", 64) + ", got sha256:" + serviceSHA,
+		},
+		{
+			name:   "not found",
+			source: "    url: " + server.URL + "/missing.yaml\n    sha256: " + serviceSHA,
+			err:    "404 Not Found",
+		},
+		{
+			name:   "size cap",
+			source: "    url: " + server.URL + "/large.yaml\n    sha256: " + serviceSHA + "\n    fetch:\n      maxSize: 1024",
+			err:    "exceeds the limit of 1024 bytes",
+		},
+		{
+			name:   "timeout",
+			source: "    url: " + server.URL + "/slow.yaml\n    sha256: " + serviceSHA + "\n    fetch:\n      timeout: 100ms",
+			err:    "Client.Timeout exceeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out string
+			var err error
+			stderr := testutils.CaptureStderr(t, func() {
+				out, err = buildSourceFixture(t, app, tt.source)
+			})
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, stderr, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, out, tt.expected)
+		})
+	}
+}
+
+func TestURLSourceCache(t *testing.T) {
+	server, requests := serveSources(t)
+	dir := t.TempDir()
+	t.Setenv("RESOURCEINJECTOR_CACHE_DIR", filepath.Join(dir, "cache"))
+	source := "    url: " + server.URL + "/service.yaml\n    sha256: " + sha256Hex([]byte(urlService))
+
+	out, err := buildSourceFixture(t, dir, source)
+	require.NoError(t, err)
+	assert.Contains(t, out, "name: fetched")
+	assert.FileExists(t, filepath.Join(dir, "cache", "url", sha256Hex([]byte(urlService))))
+	assert.EqualValues(t, 1, atomic.LoadInt32(requests))
+
+	// Verified content is not fetched again.
+	out, err = buildSourceFixture(t, dir, source)
+	require.NoError(t, err)
+	assert.Contains(t, out, "name: fetched")
+	assert.EqualValues(t, 1, atomic.LoadInt32(requests))
+}